@@ -44,6 +44,11 @@ func Unmarshal(key string, rawVal interface{}) error {
 	return viper.UnmarshalKey(key, rawVal)
 }
 
+//AllSettings returns the effective configuration merged from defaults, files and overrides
+func AllSettings() map[string]interface{} {
+	return viper.AllSettings()
+}
+
 //SetDefaultConfig - Set some default configuration in web application.
 func SetDefaultConfig(values map[string]interface{}) {
 	for k, v := range values {
@@ -2,9 +2,12 @@ package elastic
 
 import (
 	"context"
+	"strconv"
+	"time"
 
 	"github.com/olivere/elastic"
 	"github.com/v-zhidu/orb/logging"
+	"github.com/v-zhidu/orb/trace"
 )
 
 //Config is configuration struct for elasticsearch.
@@ -29,43 +32,103 @@ func NewElasticClient(esConfig *Config) (*elastic.Client, error) {
 //SearchElastic - common method to search with elastic.
 func SearchElastic(client *elastic.Client, index string, query elastic.Query,
 	sortBy string, ascending bool, from int, size int) ([]*elastic.SearchHit, error) {
-	searchResult, err := client.Search().
+	return SearchElasticWithContext(context.Background(), client, index, query, sortBy, ascending, from, size)
+}
+
+//SearchElasticWithContext - common method to search with elastic, traced as a child of the span in ctx.
+func SearchElasticWithContext(ctx context.Context, client *elastic.Client, index string, query elastic.Query,
+	sortBy string, ascending bool, from int, size int) ([]*elastic.SearchHit, error) {
+	searchResult, err := search(ctx, client, index, query, sortBy, ascending, from, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// Here's how you iterate through results with full control over each step.
+	if searchResult.Hits.TotalHits > 0 {
+		return searchResult.Hits.Hits, nil
+	}
+
+	// No hits
+	return nil, nil
+}
+
+//SearchResultWithContext - common method to search with elastic returning the whole result,
+//e.g. for the total hits of a page, traced as a child of the span in ctx.
+func SearchResultWithContext(ctx context.Context, client *elastic.Client, index string, query elastic.Query,
+	sortBy string, ascending bool, from int, size int) (*elastic.SearchResult, error) {
+	return search(ctx, client, index, query, sortBy, ascending, from, size)
+}
+
+func search(ctx context.Context, client *elastic.Client, index string, query elastic.Query,
+	sortBy string, ascending bool, from int, size int) (*elastic.SearchResult, error) {
+	ctx, span := startSpan(ctx, "elasticsearch search", index)
+	defer span.End()
+
+	search := client.Search().
 		Index(index).
 		Query(query).
 		Pretty(false).
 		From(from).Size(size).
-		Sort(sortBy, ascending).
-		Do(context.Background())
+		Sort(sortBy, ascending)
+	if deadline, ok := ctx.Deadline(); ok {
+		//let elasticsearch stop searching at the deadline rather than only abandoning the request
+		search = search.Timeout(searchTimeout(deadline))
+	}
+	searchResult, err := search.Do(ctx)
 
 	if err != nil {
+		span.RecordError(err)
 		return nil, err
 	}
+	span.SetAttribute("db.elasticsearch.hits", searchResult.Hits.TotalHits)
 
-	// Here's how you iterate through results with full control over each step.
 	if searchResult.Hits.TotalHits > 0 {
 		logging.Info("elasticsearch search hits", logging.Fields{
 			"counts":      searchResult.Hits.TotalHits,
 			"TookInMills": searchResult.TookInMillis,
 		})
-
-		return searchResult.Hits.Hits, nil
+	} else {
+		logging.Infoln("elasticsearch search no hits")
 	}
-
-	// No hits
-	logging.Infoln("elasticsearch search no hits")
-	return nil, nil
+	return searchResult, nil
 }
 
 //CountElastic - common method to count with elastic.
 func CountElastic(client *elastic.Client, index string, query elastic.Query) (int64, error) {
+	return CountElasticWithContext(context.Background(), client, index, query)
+}
+
+//CountElasticWithContext - common method to count with elastic, traced as a child of the span in ctx.
+func CountElasticWithContext(ctx context.Context, client *elastic.Client, index string,
+	query elastic.Query) (int64, error) {
+	ctx, span := startSpan(ctx, "elasticsearch count", index)
+	defer span.End()
+
 	countResult, err := client.Count().
 		Index(index).
 		Query(query).
-		Do(context.Background())
+		Do(ctx)
 
 	if err != nil {
+		span.RecordError(err)
 		return 0, err
 	}
 
 	return countResult, nil
 }
+
+//searchTimeout returns the time left until deadline in the elasticsearch time unit format
+func searchTimeout(deadline time.Time) string {
+	ms := time.Until(deadline).Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	return strconv.FormatInt(ms, 10) + "ms"
+}
+
+func startSpan(ctx context.Context, name string, index string) (context.Context, *trace.Span) {
+	ctx, span := trace.Start(ctx, name, trace.SpanKindClient)
+	span.SetAttribute("db.system", "elasticsearch")
+	span.SetAttribute("db.elasticsearch.index", index)
+	return ctx, span
+}
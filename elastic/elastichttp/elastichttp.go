@@ -0,0 +1,36 @@
+package elastichttp
+
+//This is a package that serves elasticsearch searches as paginated list responses of
+//the orb http server, it keeps the elastic package free of the http server dependencies.
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/olivere/elastic"
+	orbelastic "github.com/v-zhidu/orb/elastic"
+	orbhttp "github.com/v-zhidu/orb/http"
+)
+
+//SearchPage - searches the page requested by req, parsed by orbhttp.ParsePage, and returns the
+//source documents of the hits as a list response with the Link header set. Hits without a
+//source are kept as null so that the items match the total.
+func SearchPage(ctx context.Context, req *http.Request, client *elastic.Client, index string, query elastic.Query,
+	sortBy string, ascending bool, page orbhttp.Page) (*orbhttp.ListResponse, error) {
+	searchResult, err := orbelastic.SearchResultWithContext(ctx, client, index, query, sortBy, ascending,
+		page.Offset(), page.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]json.RawMessage, 0, len(searchResult.Hits.Hits))
+	for _, hit := range searchResult.Hits.Hits {
+		source := json.RawMessage("null")
+		if hit.Source != nil {
+			source = *hit.Source
+		}
+		items = append(items, source)
+	}
+	return orbhttp.NewPageList(ctx, req, page, items, searchResult.Hits.TotalHits), nil
+}
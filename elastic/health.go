@@ -0,0 +1,38 @@
+package elastic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/olivere/elastic"
+	"github.com/v-zhidu/orb/health"
+)
+
+//ClusterHealthChecker returns a health.Checker that fails when the cluster status is red
+func ClusterHealthChecker(client *elastic.Client) health.Checker {
+	return health.CheckerFunc(func(ctx context.Context) error {
+		res, err := client.ClusterHealth().Do(ctx)
+		if err != nil {
+			return err
+		}
+		if res.Status == "red" {
+			return fmt.Errorf("elasticsearch cluster %s status is red", res.ClusterName)
+		}
+		return nil
+	})
+}
+
+//PingChecker returns a health.Checker that pings the elasticsearch node at url
+func PingChecker(client *elastic.Client, url string) health.Checker {
+	return health.CheckerFunc(func(ctx context.Context) error {
+		_, code, err := client.Ping(url).Do(ctx)
+		if err != nil {
+			return err
+		}
+		if code != http.StatusOK {
+			return fmt.Errorf("elasticsearch node %s returns status %d", url, code)
+		}
+		return nil
+	})
+}
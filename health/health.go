@@ -0,0 +1,248 @@
+package health
+
+//This is a package that runs liveness and readiness checks of an application
+//and reports the results as JSON, e.g. for kubernetes probes.
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	//StatusUp is reported when the check passes
+	StatusUp = "up"
+	//StatusDown is reported when the check fails
+	StatusDown = "down"
+
+	//DefaultTimeout is the timeout of a check registered without WithTimeout
+	DefaultTimeout = 5 * time.Second
+)
+
+//ErrShuttingDown is reported by readiness once graceful shutdown begins
+var ErrShuttingDown = errors.New("server is shutting down")
+
+//Checker checks whether a dependency is healthy, a nil error means healthy
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+//CheckerFunc is an adapter to use ordinary functions as Checker
+type CheckerFunc func(ctx context.Context) error
+
+//Check implement Checker interface
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+//Option configures a registered check
+type Option func(*check)
+
+//WithTimeout set the maximum duration of a single check run
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *check) {
+		c.timeout = timeout
+	}
+}
+
+//WithCacheTTL reuses the last result of the check for ttl
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(c *check) {
+		c.cacheTTL = ttl
+	}
+}
+
+//Result is the outcome of a single check
+type Result struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Duration  string    `json:"duration"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+//Report is the outcome of all checks of a probe
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+type check struct {
+	sync.Mutex
+	name     string
+	checker  Checker
+	timeout  time.Duration
+	cacheTTL time.Duration
+	last     *Result
+}
+
+func (c *check) run(ctx context.Context) Result {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.last != nil && c.cacheTTL > 0 && time.Since(c.last.CheckedAt) < c.cacheTTL {
+		return *c.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- c.checker.Check(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	result := Result{
+		Name:      c.name,
+		Status:    StatusUp,
+		Duration:  time.Since(start).String(),
+		CheckedAt: start,
+	}
+	if err != nil {
+		result.Status = StatusDown
+		result.Error = err.Error()
+	}
+	c.last = &result
+
+	return result
+}
+
+//Registry holds the liveness and readiness checks of an application
+type Registry struct {
+	sync.RWMutex
+	liveness     []*check
+	readiness    []*check
+	shuttingDown int32
+}
+
+//NewRegistry returns a Registry without checks
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+//AddLivenessCheck registers a check reported by both liveness and readiness
+func (r *Registry) AddLivenessCheck(name string, checker Checker, opts ...Option) {
+	r.Lock()
+	defer r.Unlock()
+	r.liveness = append(r.liveness, newCheck(name, checker, opts))
+}
+
+//AddReadinessCheck registers a check reported by readiness only
+func (r *Registry) AddReadinessCheck(name string, checker Checker, opts ...Option) {
+	r.Lock()
+	defer r.Unlock()
+	r.readiness = append(r.readiness, newCheck(name, checker, opts))
+}
+
+//SetShuttingDown makes readiness fail from now on
+func (r *Registry) SetShuttingDown() {
+	atomic.StoreInt32(&r.shuttingDown, 1)
+}
+
+//IsShuttingDown returns true once SetShuttingDown has been called
+func (r *Registry) IsShuttingDown() bool {
+	return atomic.LoadInt32(&r.shuttingDown) == 1
+}
+
+//Liveness runs the liveness checks
+func (r *Registry) Liveness(ctx context.Context) Report {
+	r.RLock()
+	checks := append([]*check{}, r.liveness...)
+	r.RUnlock()
+
+	return runChecks(ctx, checks)
+}
+
+//Readiness runs the liveness and readiness checks, it fails without running
+//any check once the registry is shutting down
+func (r *Registry) Readiness(ctx context.Context) Report {
+	if r.IsShuttingDown() {
+		return Report{
+			Status: StatusDown,
+			Checks: []Result{{
+				Name:      "shutdown",
+				Status:    StatusDown,
+				Error:     ErrShuttingDown.Error(),
+				CheckedAt: time.Now(),
+			}},
+		}
+	}
+
+	r.RLock()
+	checks := append(append([]*check{}, r.liveness...), r.readiness...)
+	r.RUnlock()
+
+	return runChecks(ctx, checks)
+}
+
+//LivenessHandler returns a http.Handler serving the liveness report
+func (r *Registry) LivenessHandler() http.Handler {
+	return reportHandler(r.Liveness)
+}
+
+//ReadinessHandler returns a http.Handler serving the readiness report
+func (r *Registry) ReadinessHandler() http.Handler {
+	return reportHandler(r.Readiness)
+}
+
+func newCheck(name string, checker Checker, opts []Option) *check {
+	c := &check{
+		name:    name,
+		checker: checker,
+		timeout: DefaultTimeout,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func runChecks(ctx context.Context, checks []*check) Report {
+	report := Report{
+		Status: StatusUp,
+		Checks: make([]Result, len(checks)),
+	}
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c *check) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusUp {
+			report.Status = StatusDown
+		}
+	}
+
+	return report
+}
+
+func reportHandler(probe func(context.Context) Report) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		report := probe(req.Context())
+
+		rw.Header().Set("Content-Type", "application/json")
+		rw.Header().Set("Cache-Control", "no-store")
+		if report.Status != StatusUp {
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(rw).Encode(report)
+	})
+}
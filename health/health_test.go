@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRegistry_Readiness(t *testing.T) {
+	up := CheckerFunc(func(ctx context.Context) error { return nil })
+	down := CheckerFunc(func(ctx context.Context) error { return errors.New("unreachable") })
+	slow := CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+
+	tests := []struct {
+		name       string
+		liveness   map[string]Checker
+		readiness  map[string]Checker
+		shutdown   bool
+		wantStatus string
+		wantChecks int
+	}{
+		{
+			name:       "no checks",
+			wantStatus: StatusUp,
+		},
+		{
+			name:       "all checks up",
+			liveness:   map[string]Checker{"live": up},
+			readiness:  map[string]Checker{"ready": up},
+			wantStatus: StatusUp,
+			wantChecks: 2,
+		},
+		{
+			name:       "readiness check down",
+			liveness:   map[string]Checker{"live": up},
+			readiness:  map[string]Checker{"ready": down},
+			wantStatus: StatusDown,
+			wantChecks: 2,
+		},
+		{
+			name:       "check timeout",
+			readiness:  map[string]Checker{"slow": slow},
+			wantStatus: StatusDown,
+			wantChecks: 1,
+		},
+		{
+			name:       "shutting down",
+			readiness:  map[string]Checker{"ready": up},
+			shutdown:   true,
+			wantStatus: StatusDown,
+			wantChecks: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			for name, c := range tt.liveness {
+				r.AddLivenessCheck(name, c, WithTimeout(50*time.Millisecond))
+			}
+			for name, c := range tt.readiness {
+				r.AddReadinessCheck(name, c, WithTimeout(50*time.Millisecond))
+			}
+			if tt.shutdown {
+				r.SetShuttingDown()
+			}
+
+			got := r.Readiness(context.Background())
+			if got.Status != tt.wantStatus {
+				t.Errorf("Readiness() status = %v, want %v", got.Status, tt.wantStatus)
+			}
+			if len(got.Checks) != tt.wantChecks {
+				t.Errorf("Readiness() checks = %v, want %v", len(got.Checks), tt.wantChecks)
+			}
+		})
+	}
+}
+
+func TestRegistry_CacheTTL(t *testing.T) {
+	var calls int32
+	r := NewRegistry()
+	r.AddLivenessCheck("cached", CheckerFunc(func(ctx context.Context) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}), WithCacheTTL(time.Minute))
+
+	for i := 0; i < 3; i++ {
+		r.Liveness(context.Background())
+	}
+	if atomic.LoadInt32(&calls) != 1 {
+		t.Errorf("check calls = %v, want 1", calls)
+	}
+}
+
+func TestRegistry_ReadinessHandler(t *testing.T) {
+	r := NewRegistry()
+	r.AddReadinessCheck("ready", CheckerFunc(func(ctx context.Context) error { return nil }))
+
+	rw := httptest.NewRecorder()
+	r.ReadinessHandler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rw.Code != http.StatusOK {
+		t.Errorf("ReadinessHandler() code = %v, want %v", rw.Code, http.StatusOK)
+	}
+
+	r.SetShuttingDown()
+	rw = httptest.NewRecorder()
+	r.ReadinessHandler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("ReadinessHandler() code = %v, want %v", rw.Code, http.StatusServiceUnavailable)
+	}
+
+	var report Report
+	if err := json.NewDecoder(rw.Body).Decode(&report); err != nil {
+		t.Fatalf("decode report error = %v", err)
+	}
+	if report.Status != StatusDown || report.Checks[0].Error != ErrShuttingDown.Error() {
+		t.Errorf("ReadinessHandler() report = %+v", report)
+	}
+}
@@ -0,0 +1,330 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v-zhidu/orb/config"
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	//AccessLogJSON logs structured fields through the logging package
+	AccessLogJSON = "json"
+	//AccessLogCombined logs lines in the Apache combined log format
+	AccessLogCombined = "combined"
+	//AccessLogCommon logs lines in the Apache common log format
+	AccessLogCommon = "common"
+
+	redacted               = "[REDACTED]"
+	defaultAccessLogBodies = 2048
+	accessLogKey           = contextKey("accessLog")
+)
+
+var (
+	defaultAccessLogFields = []string{"method", "url", "status", "bytes", "duration", "ip"}
+	defaultRedactHeaders   = []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie",
+		APIKeyHeader, SignatureHeader}
+	defaultRedactQuery = []string{"access_token", "token", "api_key", "key", "secret", "signature"}
+)
+
+//AccessLogConfig configures the AccessLog middleware, the zero value logs the default
+//fields of every request as JSON without headers or bodies
+type AccessLogConfig struct {
+	//Format is json, combined or common, json by default
+	Format string `mapstructure:"format"`
+	//Fields selects the fields of the json format among method, url, path, query,
+	//status, bytes, duration, ip, host, proto, user_agent, referer and principal
+	Fields []string `mapstructure:"fields"`
+	//Headers are the request headers logged by the json format
+	Headers []string `mapstructure:"headers"`
+	//RedactHeaders are logged as [REDACTED], credentials headers by default
+	RedactHeaders []string `mapstructure:"redact_headers"`
+	//RedactQuery are query parameters logged as [REDACTED], access_token, token, etc. by default
+	RedactQuery []string `mapstructure:"redact_query"`
+	//LogRequestBody and LogResponseBody log the first MaxBodySize bytes of the bodies
+	LogRequestBody  bool `mapstructure:"log_request_body"`
+	LogResponseBody bool `mapstructure:"log_response_body"`
+	//MaxBodySize is 2048 bytes by default
+	MaxBodySize int `mapstructure:"max_body_size"`
+	//SampleRate is the fraction of successful requests that are logged, 1 by default,
+	//slow requests and errors are always logged
+	SampleRate float64 `mapstructure:"sample_rate"`
+	//SlowThreshold logs slower requests at warn level, ErrorThreshold at error level
+	SlowThreshold  time.Duration `mapstructure:"slow_threshold"`
+	ErrorThreshold time.Duration `mapstructure:"error_threshold"`
+	//Output receives the lines instead of the logging package when it is set
+	Output io.Writer `mapstructure:"-"`
+}
+
+//AccessLogConfigFromConfig loads an AccessLogConfig from the configuration under configKey
+func AccessLogConfigFromConfig(configKey string) (AccessLogConfig, error) {
+	var c AccessLogConfig
+	if err := config.Unmarshal(configKey, &c); err != nil {
+		logging.Error("failed to load access log config", logging.Fields{
+			"key": configKey,
+		}, err)
+		return c, err
+	}
+	return c, nil
+}
+
+//AccessLog is a middleware logging a line per request once the response is written,
+//the level is escalated to warn for slow requests and to error for very slow requests
+//and 5xx responses
+func AccessLog(c AccessLogConfig) Middleware {
+	if len(c.Format) == 0 {
+		c.Format = AccessLogJSON
+	}
+	if len(c.Fields) == 0 {
+		c.Fields = defaultAccessLogFields
+	}
+	if c.RedactHeaders == nil {
+		c.RedactHeaders = defaultRedactHeaders
+	}
+	if c.RedactQuery == nil {
+		c.RedactQuery = defaultRedactQuery
+	}
+	if c.MaxBodySize <= 0 {
+		c.MaxBodySize = defaultAccessLogBodies
+	}
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		c.SampleRate = 1
+	}
+	l := &accessLogger{config: c}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			//a request is logged once, by the outermost access log, e.g. the one of a version
+			//selector or of Use rather than the one of the route
+			if r.Context().Value(accessLogKey) != nil {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), accessLogKey, true))
+
+			start := time.Now()
+			var requestBody []byte
+			if c.LogRequestBody && r.Body != nil {
+				requestBody, _ = ioutil.ReadAll(io.LimitReader(r.Body, int64(c.MaxBodySize)+1))
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(requestBody), r.Body), r.Body}
+			}
+
+			rec := &accessLogRecorder{responseRecorder: newResponseRecorder(rw)}
+			if c.LogResponseBody {
+				rec.limit = c.MaxBodySize + 1
+			}
+			next.ServeHTTP(rec, r)
+
+			l.log(r, rec, requestBody, time.Since(start))
+		})
+	}
+}
+
+//accessLogRecorder keeps the beginning of the response body
+type accessLogRecorder struct {
+	*responseRecorder
+	body  []byte
+	limit int
+}
+
+func (r *accessLogRecorder) Write(b []byte) (int, error) {
+	if room := r.limit - len(r.body); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		r.body = append(r.body, b[:room]...)
+	}
+	return r.responseRecorder.Write(b)
+}
+
+type accessLogger struct {
+	config AccessLogConfig
+	mu     sync.Mutex
+}
+
+func (l *accessLogger) log(r *http.Request, rec *accessLogRecorder, requestBody []byte, duration time.Duration) {
+	c := l.config
+	level := "info"
+	switch {
+	case rec.status >= http.StatusInternalServerError || (c.ErrorThreshold > 0 && duration >= c.ErrorThreshold):
+		level = "error"
+	case c.SlowThreshold > 0 && duration >= c.SlowThreshold:
+		level = "warn"
+	case rec.status < http.StatusBadRequest && c.SampleRate < 1 && rand.Float64() >= c.SampleRate:
+		return
+	}
+
+	if c.Format == AccessLogCombined || c.Format == AccessLogCommon {
+		l.write(r, level, l.line(r, rec), nil)
+		return
+	}
+
+	fields := logging.Fields{}
+	for _, field := range c.Fields {
+		if value, ok := l.field(field, r, rec, duration); ok {
+			fields[field] = value
+		}
+	}
+	for _, name := range c.Headers {
+		if values := r.Header.Values(name); len(values) > 0 {
+			key := "header_" + strings.ToLower(strings.Replace(name, "-", "_", -1))
+			fields[key] = strings.Join(values, ", ")
+			if l.redactHeader(name) {
+				fields[key] = redacted
+			}
+		}
+	}
+	if c.LogRequestBody && len(requestBody) > 0 {
+		fields["request_body"] = l.truncate(requestBody)
+	}
+	if c.LogResponseBody && len(rec.body) > 0 {
+		fields["response_body"] = l.truncate(rec.body)
+	}
+	l.write(r, level, "access", fields)
+}
+
+func (l *accessLogger) field(name string, r *http.Request, rec *accessLogRecorder, duration time.Duration) (interface{}, bool) {
+	switch name {
+	case "method":
+		return r.Method, true
+	case "url":
+		return l.redactURL(r.URL), true
+	case "path":
+		return r.URL.Path, true
+	case "query":
+		return l.redactQuery(r.URL.Query()), len(r.URL.RawQuery) > 0
+	case "status":
+		return rec.status, true
+	case "bytes":
+		return rec.size, true
+	case "duration":
+		return duration.String(), true
+	case "ip":
+		return ClientIPKey(r), true
+	case "host":
+		return r.Host, true
+	case "proto":
+		return r.Proto, true
+	case "user_agent":
+		return r.UserAgent(), len(r.UserAgent()) > 0
+	case "referer":
+		return l.redactReferer(r.Referer()), len(r.Referer()) > 0
+	case "principal":
+		if principal := GetPrincipal(r.Context()); principal != nil {
+			return principal.ID, true
+		}
+	}
+	return nil, false
+}
+
+//line formats the request in the Apache common or combined log format
+func (l *accessLogger) line(r *http.Request, rec *accessLogRecorder) string {
+	user := "-"
+	if principal := GetPrincipal(r.Context()); principal != nil {
+		user = principal.ID
+	}
+	size := "-"
+	if rec.size > 0 {
+		size = strconv.Itoa(rec.size)
+	}
+	line := fmt.Sprintf("%s - %s [%s] %q %d %s", ClientIPKey(r), user,
+		time.Now().Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method+" "+l.redactURL(r.URL)+" "+r.Proto, rec.status, size)
+	if l.config.Format == AccessLogCombined {
+		line += fmt.Sprintf(" %q %q", l.redactReferer(r.Referer()), r.UserAgent())
+	}
+	return line
+}
+
+func (l *accessLogger) write(r *http.Request, level string, message string, fields logging.Fields) {
+	if l.config.Output != nil {
+		var line []byte
+		if fields == nil {
+			line = []byte(message)
+		} else {
+			entry := logging.Fields{"level": level, "msg": message, "time": time.Now().Format(time.RFC3339)}
+			for k, v := range fields {
+				entry[k] = v
+			}
+			line, _ = json.Marshal(entry)
+		}
+		l.mu.Lock()
+		l.config.Output.Write(append(line, '\n'))
+		l.mu.Unlock()
+		return
+	}
+
+	entry := logging.WithContext(r.Context())
+	for k, v := range fields {
+		entry = entry.WithField(k, v)
+	}
+	switch level {
+	case "error":
+		entry.Error(message)
+	case "warn":
+		entry.Warn(message)
+	default:
+		entry.Info(message)
+	}
+}
+
+func (l *accessLogger) redactHeader(name string) bool {
+	for _, h := range l.config.RedactHeaders {
+		if strings.EqualFold(h, name) {
+			return true
+		}
+	}
+	return false
+}
+
+//redactURL returns the request uri with the values of sensitive query parameters replaced
+func (l *accessLogger) redactURL(u *url.URL) string {
+	if len(u.RawQuery) == 0 {
+		return u.RequestURI()
+	}
+	redactedURL := *u
+	redactedURL.RawQuery = l.redactQuery(u.Query())
+	return redactedURL.RequestURI()
+}
+
+func (l *accessLogger) redactReferer(referer string) string {
+	u, err := url.Parse(referer)
+	if err != nil || len(u.RawQuery) == 0 {
+		return referer
+	}
+	u.RawQuery = l.redactQuery(u.Query())
+	return u.String()
+}
+
+func (l *accessLogger) redactQuery(query url.Values) string {
+	for name := range query {
+		for _, secret := range l.config.RedactQuery {
+			if strings.EqualFold(name, secret) {
+				query[name] = []string{redacted}
+			}
+		}
+	}
+	return strings.Replace(query.Encode(), url.QueryEscape(redacted), redacted, -1)
+}
+
+func (l *accessLogger) truncate(body []byte) string {
+	if len(body) > l.config.MaxBodySize {
+		return string(body[:l.config.MaxBodySize]) + "...(truncated)"
+	}
+	return string(body)
+}
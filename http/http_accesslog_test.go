@@ -0,0 +1,206 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAccessLog(t *testing.T) {
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch r.URL.Path {
+		case "/slow":
+			time.Sleep(20 * time.Millisecond)
+		case "/fail":
+			rw.WriteHeader(http.StatusBadGateway)
+		}
+		rw.Write(append([]byte("echo:"), body...))
+	})
+
+	tests := []struct {
+		name   string
+		config AccessLogConfig
+		path   string
+		header map[string]string
+		body   string
+		want   map[string]interface{}
+		absent []string
+	}{
+		{
+			name: "default fields with redacted query",
+			path: "/alerts?access_token=secret&q=cpu",
+			header: map[string]string{
+				"Authorization": "Bearer secret",
+			},
+			want: map[string]interface{}{
+				"level":  "info",
+				"method": "POST",
+				"url":    "/alerts?access_token=[REDACTED]&q=cpu",
+				"status": float64(200),
+				"bytes":  float64(5),
+				"ip":     "192.0.2.1",
+			},
+			absent: []string{"header_authorization", "request_body", "response_body"},
+		},
+		{
+			name:   "allowed and redacted headers",
+			config: AccessLogConfig{Fields: []string{"path", "user_agent"}, Headers: []string{"Authorization", "X-Request-Id"}},
+			path:   "/alerts",
+			header: map[string]string{
+				"Authorization": "Bearer secret",
+				"X-Request-Id":  "abc",
+				"User-Agent":    "curl",
+			},
+			want: map[string]interface{}{
+				"path":                 "/alerts",
+				"user_agent":           "curl",
+				"header_authorization": "[REDACTED]",
+				"header_x_request_id":  "abc",
+			},
+			absent: []string{"method", "status"},
+		},
+		{
+			name:   "bodies are capped",
+			config: AccessLogConfig{LogRequestBody: true, LogResponseBody: true, MaxBodySize: 4},
+			path:   "/alerts",
+			body:   "abcdefgh",
+			want: map[string]interface{}{
+				"request_body":  "abcd...(truncated)",
+				"response_body": "echo...(truncated)",
+				"bytes":         float64(13),
+			},
+		},
+		{
+			name:   "slow request escalates",
+			config: AccessLogConfig{SlowThreshold: 10 * time.Millisecond, ErrorThreshold: time.Hour},
+			path:   "/slow",
+			want:   map[string]interface{}{"level": "warn"},
+		},
+		{
+			name:   "server error escalates",
+			config: AccessLogConfig{},
+			path:   "/fail",
+			want:   map[string]interface{}{"level": "error", "status": float64(502)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			tt.config.Output = &out
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rw := httptest.NewRecorder()
+			AccessLog(tt.config)(handler).ServeHTTP(rw, req)
+
+			if rw.Body.String() != "echo:"+tt.body {
+				t.Errorf("handler body = %q, want the request body echoed", rw.Body.String())
+			}
+			var entry map[string]interface{}
+			if err := json.Unmarshal(out.Bytes(), &entry); err != nil {
+				t.Fatalf("decode log line %q error = %v", out.String(), err)
+			}
+			for k, v := range tt.want {
+				if entry[k] != v {
+					t.Errorf("%s = %v, want %v", k, entry[k], v)
+				}
+			}
+			for _, k := range tt.absent {
+				if _, ok := entry[k]; ok {
+					t.Errorf("%s = %v, want absent", k, entry[k])
+				}
+			}
+			if strings.Contains(out.String(), "secret") {
+				t.Errorf("log line %s leaks a secret", out.String())
+			}
+		})
+	}
+}
+
+func TestAccessLog_CombinedFormat(t *testing.T) {
+	var out bytes.Buffer
+	h := AccessLog(AccessLogConfig{Format: AccessLogCombined, Output: &out})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("ok"))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "/alerts?token=secret", nil)
+	req.Header.Set("Referer", "https://example.com/?key=secret")
+	req.Header.Set("User-Agent", "curl")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	line := out.String()
+	for _, want := range []string{`192.0.2.1 - - [`, `] "GET /alerts?token=[REDACTED] HTTP/1.1" 200 2 "https://example.com/?key=[REDACTED]" "curl"`} {
+		if !strings.Contains(line, want) {
+			t.Errorf("line = %q, want it to contain %q", line, want)
+		}
+	}
+}
+
+func TestAccessLog_Sampling(t *testing.T) {
+	var out bytes.Buffer
+	h := AccessLog(AccessLogConfig{SampleRate: 0.0001, Output: &out})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			rw.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	for i := 0; i < 100; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/ok", nil))
+	}
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	//the sample of successful requests is very unlikely to keep more than a few lines
+	if lines := strings.Count(out.String(), "\n"); lines < 1 || lines > 5 {
+		t.Errorf("logged %d lines, want the 404 and few sampled requests", lines)
+	}
+	if !strings.Contains(out.String(), `"status":404`) {
+		t.Errorf("log = %s, want the 404 request", out.String())
+	}
+}
+
+func TestHTTPServer_AccessLogRoutes(t *testing.T) {
+	var out bytes.Buffer
+	s := NewHTTPServer("localhost", 0, "/api", WithAccessLog(AccessLogConfig{Fields: []string{"path", "status"}, Output: &out}))
+	ok := ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		return "ok", 0
+	})
+	s.RegisterApiHandler("/private", ok, RequireAuth())
+	s.RegisterStreamHandler("/tail", StreamHandlerFunc(func(ctx context.Context, req *http.Request, stream Stream) error {
+		return stream.Send("line")
+	}), WithHeartbeat(0))
+	s.Version("v1").RegisterApiHandler("/alerts", ok)
+
+	tests := []struct {
+		path    string
+		version string
+		status  float64
+	}{
+		{"/api/private", "", 401},
+		{"/api/tail", "", 200},
+		{"/api/alerts", "", 200},
+		{"/api/alerts", "v9", 400},
+	}
+	for _, tt := range tests {
+		out.Reset()
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if len(tt.version) > 0 {
+			req.Header.Set(defaultVersionHeader, tt.version)
+		}
+		s.Handler().ServeHTTP(httptest.NewRecorder(), req)
+
+		lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil || len(lines) != 1 {
+			t.Fatalf("%s %s access log = %q, want a single line", tt.path, tt.version, out.String())
+		}
+		if entry["path"] != tt.path || entry["status"] != tt.status {
+			t.Errorf("%s %s access log = %v, want status %v", tt.path, tt.version, entry, tt.status)
+		}
+	}
+}
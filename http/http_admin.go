@@ -0,0 +1,287 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/v-zhidu/orb/config"
+	"github.com/v-zhidu/orb/logging"
+)
+
+//ErrAdminAuthRequired is returned by Start when the admin endpoints have no authenticator
+var ErrAdminAuthRequired = errors.New("admin endpoints require an authenticator")
+
+var (
+	defaultRedactKeys = []string{"password", "secret", "token", "key", "credential", "private"}
+	logLevels         = []string{"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"}
+)
+
+//AdminConfig configures the diagnostics endpoints served on a separate port
+type AdminConfig struct {
+	Host string
+	Port int
+	//Listener serves the admin endpoints on an existing listener instead of host:port
+	Listener net.Listener
+	//Authenticators authenticate the admin requests, at least one is required
+	Authenticators []Authenticator
+	//Roles the principal must have, none by default
+	Roles []string
+	//RedactKeys are the substrings of configuration keys whose values are redacted,
+	//password, secret, token, key, credential and private by default
+	RedactKeys []string
+}
+
+//WithAdmin serves the diagnostics endpoints on a separate port:
+//
+//	/debug/pprof/  profiles and goroutine dumps
+//	/buildinfo     go version, module versions and runtime statistics
+//	/config        the effective configuration with secrets redacted
+//	/routes        the registered routes
+//	/loglevel      GET the level, PUT {"level": "debug"} to change it
+func WithAdmin(c AdminConfig) ServerOption {
+	return func(s *HTTPServer) {
+		if c.RedactKeys == nil {
+			c.RedactKeys = defaultRedactKeys
+		}
+		s.admin = &c
+	}
+}
+
+//AdminAddr returns the address of the admin endpoints, empty before Start
+func (s *HTTPServer) AdminAddr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.admin == nil || s.admin.Listener == nil {
+		return ""
+	}
+	return s.admin.Listener.Addr().String()
+}
+
+//AdminHandler returns the diagnostics endpoints behind the admin authentication
+func (s *HTTPServer) AdminHandler() http.Handler {
+	c := s.admin
+	if c == nil {
+		c = &AdminConfig{RedactKeys: defaultRedactKeys}
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/buildinfo", s.buildInfo)
+	mux.HandleFunc("/config", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, redactConfig(config.AllSettings(), c.RedactKeys))
+	})
+	mux.HandleFunc("/routes", s.listRoutes)
+	mux.HandleFunc("/loglevel", logLevel)
+
+	var handler http.Handler = mux
+	handler = requireAuth(c.Roles...)(handler)
+	handler = Authenticate(c.Authenticators...)(handler)
+	return handler
+}
+
+func (s *HTTPServer) startAdmin() error {
+	c := s.admin
+	if len(c.Authenticators) == 0 {
+		return ErrAdminAuthRequired
+	}
+
+	listener := c.Listener
+	if listener == nil {
+		var err error
+		listener, err = net.Listen("tcp", net.JoinHostPort(c.Host, strconv.Itoa(c.Port)))
+		if err != nil {
+			return err
+		}
+	}
+
+	server := &http.Server{
+		Handler:           s.AdminHandler(),
+		ReadHeaderTimeout: s.readHeaderTimeout,
+		IdleTimeout:       s.idleTimeout,
+	}
+	s.mu.Lock()
+	c.Listener = listener
+	s.adminServer = server
+	s.mu.Unlock()
+
+	logging.Info("http admin server started and served", logging.Fields{
+		"addr": listener.Addr().String(),
+	})
+	go func() {
+		if err := server.Serve(listener); err != http.ErrServerClosed {
+			logging.Error("http admin server serve failed", logging.Fields{
+				"addr": listener.Addr().String(),
+			}, err)
+		}
+	}()
+	return nil
+}
+
+func (s *HTTPServer) buildInfo(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	startTime := s.startTime
+	s.mu.Unlock()
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	info := map[string]interface{}{
+		"goVersion":  runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"cpus":       runtime.NumCPU(),
+		"goroutines": runtime.NumGoroutine(),
+		"heapAlloc":  mem.HeapAlloc,
+		"numGC":      mem.NumGC,
+		"startTime":  startTime.Format(time.RFC3339),
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		info["path"] = bi.Path
+		info["main"] = bi.Main
+		info["deps"] = bi.Deps
+		settings := make(map[string]string)
+		for _, setting := range bi.Settings {
+			settings[setting.Key] = setting.Value
+		}
+		info["settings"] = settings
+	}
+	writeJSON(rw, info)
+}
+
+func (s *HTTPServer) listRoutes(rw http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	routes := append([]*route{}, s.routes...)
+	s.mu.Unlock()
+
+	list := make([]map[string]interface{}, 0, len(routes))
+	for _, route := range routes {
+		path, methods := openAPIPath(route)
+		item := map[string]interface{}{
+			"pattern": route.pattern,
+			"path":    path,
+			"methods": methods,
+			"kind":    route.kind,
+		}
+		if route.handlerType != nil {
+			item["handler"] = route.handlerType.String()
+		}
+		if len(route.summary) > 0 {
+			item["summary"] = route.summary
+		}
+		if len(route.version) > 0 {
+			item["version"] = route.version
+		}
+		if d := route.deprecation; d != nil {
+			deprecated := map[string]interface{}{
+				"since": d.since.Format(time.RFC3339),
+				"calls": atomic.LoadInt64(&d.calls),
+			}
+			if !d.sunset.IsZero() {
+				deprecated["sunset"] = d.sunset.Format(time.RFC3339)
+			}
+			item["deprecated"] = deprecated
+		}
+		list = append(list, item)
+	}
+	writeJSON(rw, list)
+}
+
+func logLevel(rw http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		var body struct {
+			Level string `json:"level"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			WriteError(rw, http.StatusBadRequest, "body must be {\"level\": \"<level>\"}")
+			return
+		}
+		level := strings.ToLower(body.Level)
+		if !containsString(logLevels, level) {
+			WriteError(rw, http.StatusBadRequest, "level must be one of "+strings.Join(logLevels, ", "))
+			return
+		}
+		logging.SetLevel(level)
+		logging.Warn("log level changed", logging.Fields{
+			"level": level,
+			"by":    GetPrincipal(r.Context()).ID,
+		})
+	default:
+		rw.Header().Set("Allow", "GET, PUT")
+		WriteError(rw, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeJSON(rw, map[string]string{"level": logging.GetLevel()})
+}
+
+//redactConfig returns a copy of settings with the values of secret keys replaced
+func redactConfig(settings map[string]interface{}, redactKeys []string) map[string]interface{} {
+	redactedSettings := make(map[string]interface{}, len(settings))
+	for key, value := range settings {
+		redactedSettings[key] = redacted
+		if !isSecretKey(key, redactKeys) {
+			redactedSettings[key] = redactValue(value, redactKeys)
+		}
+	}
+	return redactedSettings
+}
+
+func redactValue(value interface{}, redactKeys []string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return redactConfig(v, redactKeys)
+	case map[interface{}]interface{}:
+		//yaml lists of objects are decoded with interface keys
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = item
+		}
+		return redactConfig(m, redactKeys)
+	case []interface{}:
+		items := make([]interface{}, len(v))
+		for i, item := range v {
+			items[i] = redactValue(item, redactKeys)
+		}
+		return items
+	}
+	return value
+}
+
+func isSecretKey(key string, redactKeys []string) bool {
+	for _, redactKey := range redactKeys {
+		if strings.Contains(strings.ToLower(key), strings.ToLower(redactKey)) {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(rw http.ResponseWriter, v interface{}) {
+	rw.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(rw)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(v)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/v-zhidu/orb/config"
+	"github.com/v-zhidu/orb/logging"
+)
+
+func TestHTTPServer_AdminHandler(t *testing.T) {
+	config.SetDefaultConfig(map[string]interface{}{
+		"wechat.corpid":     "ww123",
+		"wechat.corpsecret": "s3cr3t",
+		"apikeys":           []interface{}{map[string]interface{}{"key": "k1", "name": "ops"}},
+	})
+	defer logging.SetLevel(logging.GetLevel())
+
+	s := NewHTTPServer("localhost", 0, "/api", WithAdmin(AdminConfig{
+		Authenticators: []Authenticator{NewAPIKeyAuthenticator([]APIKey{
+			{Key: "admin-key", Name: "admin", Roles: []string{"admin"}},
+			{Key: "user-key", Name: "user"},
+		})},
+		Roles: []string{"admin"},
+	}))
+	s.RegisterApiHandler("/alerts", searchHandler{}, WithSummary("Search alerts", ""))
+	h := s.AdminHandler()
+
+	tests := []struct {
+		name     string
+		method   string
+		url      string
+		key      string
+		body     string
+		status   int
+		contains []string
+		excludes []string
+	}{
+		{"anonymous", "GET", "/routes", "", "", 401, nil, nil},
+		{"missing role", "GET", "/routes", "user-key", "", 403, nil, nil},
+		{"routes", "GET", "/routes", "admin-key", "", 200, []string{`"pattern": "/api/alerts"`, `"summary": "Search alerts"`, `"kind": "api"`}, nil},
+		{"config is redacted", "GET", "/config", "admin-key", "", 200, []string{`"corpid": "ww123"`, `"corpsecret": "[REDACTED]"`, `"apikeys": "[REDACTED]"`}, []string{"s3cr3t", "k1"}},
+		{"build info", "GET", "/buildinfo", "admin-key", "", 200, []string{`"goVersion"`, `"goroutines"`}, nil},
+		{"goroutine dump", "GET", "/debug/pprof/goroutine?debug=1", "admin-key", "", 200, []string{"goroutine profile"}, nil},
+		{"set log level", "PUT", "/loglevel", "admin-key", `{"level": "debug"}`, 200, []string{`"level": "debug"`}, nil},
+		{"get log level", "GET", "/loglevel", "admin-key", "", 200, []string{`"level": "debug"`}, nil},
+		{"invalid log level", "PUT", "/loglevel", "admin-key", `{"level": "verbose"}`, 400, nil, nil},
+		{"log level method", "POST", "/loglevel", "admin-key", `{"level": "info"}`, 405, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			if len(tt.key) > 0 {
+				req.Header.Set(APIKeyHeader, tt.key)
+			}
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, req)
+
+			if rw.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body %s", rw.Code, tt.status, rw.Body.String())
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(rw.Body.String(), want) {
+					t.Errorf("body = %s, want it to contain %s", rw.Body.String(), want)
+				}
+			}
+			for _, secret := range tt.excludes {
+				if strings.Contains(rw.Body.String(), secret) {
+					t.Errorf("body = %s, leaks %s", rw.Body.String(), secret)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPServer_AdminStart(t *testing.T) {
+	s := NewHTTPServer("127.0.0.1", 0, "", WithAdmin(AdminConfig{Host: "127.0.0.1"}))
+	if err := s.Start(context.Background()); err != ErrAdminAuthRequired {
+		t.Fatalf("Start() without admin authenticator error = %v, want %v", err, ErrAdminAuthRequired)
+	}
+
+	adminListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = NewHTTPServer("127.0.0.1", 0, "", WithAdmin(AdminConfig{
+		Listener:       adminListener,
+		Authenticators: []Authenticator{NewAPIKeyAuthenticator([]APIKey{{Key: "admin-key", Name: "admin"}})},
+	}))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Shutdown(context.Background())
+
+	req, _ := http.NewRequest(http.MethodGet, "http://"+s.AdminAddr()+"/loglevel", nil)
+	req.Header.Set(APIKeyHeader, "admin-key")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("admin request error = %v", err)
+	}
+	defer rsp.Body.Close()
+	var body map[string]string
+	json.NewDecoder(rsp.Body).Decode(&body)
+	if rsp.StatusCode != http.StatusOK || len(body["level"]) == 0 {
+		t.Errorf("admin response = %d %v, want 200 with the level", rsp.StatusCode, body)
+	}
+}
@@ -0,0 +1,623 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v-zhidu/orb/config"
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	//PrincipalKey context key of the authenticated principal
+	PrincipalKey = contextKey("principal")
+	challengeKey = contextKey("challenge")
+
+	//APIKeyHeader is the header carrying a static api key
+	APIKeyHeader = "X-API-Key"
+	//SignatureKeyHeader is the header carrying the id of the HMAC secret
+	SignatureKeyHeader = "X-Signature-Key"
+	//SignatureTimestampHeader is the header carrying the unix time the request was signed at
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	//SignatureHeader is the header carrying the hex encoded HMAC-SHA256 signature
+	SignatureHeader = "X-Signature"
+
+	defaultSignatureWindow = 5 * time.Minute
+	defaultMaxSignedBody   = 10 << 20
+)
+
+var (
+	//ErrNoCredentials is returned by an Authenticator when the request does not carry its credentials
+	ErrNoCredentials = errors.New("no credentials")
+	//ErrInvalidCredentials is returned by an Authenticator when the credentials are rejected
+	ErrInvalidCredentials = errors.New("invalid credentials")
+	//ErrBodyTooLarge is returned when a request body buffered to be verified exceeds its limit
+	ErrBodyTooLarge = &ErrorResponse{Code: http.StatusRequestEntityTooLarge, Message: "request body too large"}
+)
+
+//Principal is the authenticated caller of a request
+type Principal struct {
+	ID     string                 `json:"id"`
+	Method string                 `json:"method"`
+	Roles  []string               `json:"roles,omitempty"`
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+//HasRole returns true if the principal is granted the role
+func (p *Principal) HasRole(role string) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+//GetPrincipal returns the authenticated principal in ctx, nil for anonymous requests
+func GetPrincipal(ctx context.Context) *Principal {
+	if ctx == nil {
+		return nil
+	}
+	principal, _ := ctx.Value(PrincipalKey).(*Principal)
+	return principal
+}
+
+//Authenticator authenticates the caller of a request
+type Authenticator interface {
+	//Authenticate returns ErrNoCredentials when the request does not carry
+	//credentials of this authenticator, so that the next one is tried
+	Authenticate(req *http.Request) (*Principal, error)
+}
+
+//Challenger is implemented by the authenticators naming their scheme in the
+//WWW-Authenticate header of the 401 responses, Bearer is sent for the others
+type Challenger interface {
+	Challenge() string
+}
+
+func challenge(authenticator Authenticator) string {
+	if c, ok := authenticator.(Challenger); ok {
+		return c.Challenge()
+	}
+	return "Bearer"
+}
+
+//Authenticate is a server middleware that places the principal of the first
+//matching authenticator into the request context. Requests without credentials
+//pass through anonymously, use RequireAuth to protect a route.
+func Authenticate(authenticators ...Authenticator) Middleware {
+	challenges := make([]string, 0, len(authenticators))
+	for _, authenticator := range authenticators {
+		challenges = append(challenges, challenge(authenticator))
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			//RequireAuth challenges anonymous requests with the configured schemes
+			r = r.WithContext(context.WithValue(r.Context(), challengeKey, challenges))
+			for _, authenticator := range authenticators {
+				principal, err := authenticator.Authenticate(r)
+				if err == ErrNoCredentials {
+					continue
+				}
+				if err != nil {
+					logging.Warn("authenticate request failed", logging.Fields{
+						"url":   r.RequestURI,
+						"ip":    r.RemoteAddr,
+						"error": err.Error(),
+					})
+					if e, ok := err.(StatusError); ok {
+						WriteError(rw, e.StatusCode(), e.Error())
+						return
+					}
+					rw.Header().Set("WWW-Authenticate", challenge(authenticator))
+					WriteError(rw, http.StatusUnauthorized, err.Error())
+					return
+				}
+
+				r = r.WithContext(context.WithValue(r.Context(), PrincipalKey, principal))
+				break
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+//RequireAuth rejects anonymous requests to the route with 401, and requests
+//of principals lacking any of the roles with 403
+func RequireAuth(roles ...string) RouteOption {
+	return WithRouteMiddleware(requireAuth(roles...))
+}
+
+func requireAuth(roles ...string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			principal := GetPrincipal(r.Context())
+			if principal == nil {
+				challenges, _ := r.Context().Value(challengeKey).([]string)
+				if len(challenges) == 0 {
+					challenges = []string{"Bearer"}
+				}
+				for _, c := range challenges {
+					rw.Header().Add("WWW-Authenticate", c)
+				}
+				WriteError(rw, http.StatusUnauthorized, "authentication required")
+				return
+			}
+			for _, role := range roles {
+				if !principal.HasRole(role) {
+					WriteError(rw, http.StatusForbidden, fmt.Sprintf("role %s required", role))
+					return
+				}
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// ----------------------------------------------------------------------------
+// API keys
+// ---------------------------------------------------------------------------
+
+//APIKey is a static api key and the principal it authenticates
+type APIKey struct {
+	Key   string   `mapstructure:"key"`
+	Name  string   `mapstructure:"name"`
+	Roles []string `mapstructure:"roles"`
+}
+
+//APIKeyAuthenticator authenticates requests by the X-API-Key header
+//or an "Authorization: ApiKey <key>" header
+type APIKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]APIKey
+}
+
+//NewAPIKeyAuthenticator returns an authenticator accepting keys
+func NewAPIKeyAuthenticator(keys []APIKey) *APIKeyAuthenticator {
+	a := &APIKeyAuthenticator{
+		keys: make(map[[sha256.Size]byte]APIKey, len(keys)),
+	}
+	for _, key := range keys {
+		//keys are looked up by their digest so the comparison does not leak timing
+		a.keys[sha256.Sum256([]byte(key.Key))] = key
+	}
+	return a
+}
+
+//APIKeysFromConfig returns an authenticator accepting the api keys
+//configured as a list of {key, name, roles} under configKey
+func APIKeysFromConfig(configKey string) (*APIKeyAuthenticator, error) {
+	var keys []APIKey
+	if err := config.Unmarshal(configKey, &keys); err != nil {
+		logging.Error("failed to load api keys", logging.Fields{
+			"key": configKey,
+		}, err)
+		return nil, err
+	}
+
+	return NewAPIKeyAuthenticator(keys), nil
+}
+
+//Authenticate implement Authenticator interface
+func (a *APIKeyAuthenticator) Authenticate(req *http.Request) (*Principal, error) {
+	value := req.Header.Get(APIKeyHeader)
+	if len(value) == 0 {
+		value = authorizationValue(req, "ApiKey")
+	}
+	if len(value) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	key, ok := a.keys[sha256.Sum256([]byte(value))]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{
+		ID:     key.Name,
+		Method: "apikey",
+		Roles:  key.Roles,
+	}, nil
+}
+
+//Challenge implement Challenger interface
+func (a *APIKeyAuthenticator) Challenge() string {
+	return "ApiKey"
+}
+
+// ----------------------------------------------------------------------------
+// JWT
+// ---------------------------------------------------------------------------
+
+//JWTConfig configures the validation of JWT bearer tokens
+type JWTConfig struct {
+	//Secret validates HS256 tokens, HS256 is rejected when it is empty
+	Secret []byte
+	//JWKSFile is a local JWKS document validating RS256 tokens by their kid,
+	//RS256 is rejected when it is empty
+	JWKSFile string
+	//Issuer and Audience are checked against the iss and aud claims when set
+	Issuer   string
+	Audience string
+	//Leeway tolerates clock skew when checking exp and nbf
+	Leeway time.Duration
+}
+
+//JWTAuthenticator authenticates requests by an "Authorization: Bearer <jwt>" header
+type JWTAuthenticator struct {
+	config  JWTConfig
+	rsaKeys map[string]*rsa.PublicKey
+}
+
+//NewJWTAuthenticator returns a JWT authenticator, the JWKS file is loaded once
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	a := &JWTAuthenticator{
+		config: config,
+	}
+	if len(config.JWKSFile) > 0 {
+		keys, err := LoadJWKS(config.JWKSFile)
+		if err != nil {
+			logging.Error("failed to load jwks file", logging.Fields{
+				"file": config.JWKSFile,
+			}, err)
+			return nil, err
+		}
+		a.rsaKeys = keys
+	}
+
+	return a, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+//LoadJWKS returns the RSA public keys of a JWKS file by their kid
+func LoadJWKS(path string) (map[string]*rsa.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range jwks.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %s: invalid modulus: %v", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwk %s: invalid exponent: %v", key.Kid, err)
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+
+	return keys, nil
+}
+
+//Authenticate implement Authenticator interface
+func (a *JWTAuthenticator) Authenticate(req *http.Request) (*Principal, error) {
+	token := authorizationValue(req, "Bearer")
+	if len(token) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	claims, err := a.verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	principal := &Principal{
+		Method: "jwt",
+		Claims: claims,
+	}
+	principal.ID, _ = claims["sub"].(string)
+	switch roles := claims["roles"].(type) {
+	case []interface{}:
+		for _, role := range roles {
+			if r, ok := role.(string); ok {
+				principal.Roles = append(principal.Roles, r)
+			}
+		}
+	case string:
+		principal.Roles = strings.Fields(roles)
+	}
+	if scope, ok := claims["scope"].(string); ok {
+		principal.Roles = append(principal.Roles, strings.Fields(scope)...)
+	}
+
+	return principal, nil
+}
+
+//Challenge implement Challenger interface
+func (a *JWTAuthenticator) Challenge() string {
+	return "Bearer"
+}
+
+func (a *JWTAuthenticator) verify(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidCredentials
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	switch header.Alg {
+	case "HS256":
+		if len(a.config.Secret) == 0 {
+			return nil, ErrInvalidCredentials
+		}
+		mac := hmac.New(sha256.New, a.config.Secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), signature) {
+			return nil, ErrInvalidCredentials
+		}
+	case "RS256":
+		key, ok := a.rsaKeys[header.Kid]
+		if !ok {
+			return nil, ErrInvalidCredentials
+		}
+		digest := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, ErrInvalidCredentials
+		}
+	default:
+		return nil, ErrInvalidCredentials
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if err := a.validateClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := claims["exp"].(float64); ok {
+		if now.After(time.Unix(int64(exp), 0).Add(a.config.Leeway)) {
+			return errors.New("token is expired")
+		}
+	}
+	if nbf, ok := claims["nbf"].(float64); ok {
+		if now.Add(a.config.Leeway).Before(time.Unix(int64(nbf), 0)) {
+			return errors.New("token is not valid yet")
+		}
+	}
+	if len(a.config.Issuer) > 0 && claims["iss"] != a.config.Issuer {
+		return errors.New("token issuer is invalid")
+	}
+	if len(a.config.Audience) > 0 {
+		valid := false
+		switch aud := claims["aud"].(type) {
+		case string:
+			valid = aud == a.config.Audience
+		case []interface{}:
+			for _, v := range aud {
+				if v == a.config.Audience {
+					valid = true
+				}
+			}
+		}
+		if !valid {
+			return errors.New("token audience is invalid")
+		}
+	}
+
+	return nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ----------------------------------------------------------------------------
+// HMAC signed requests
+// ---------------------------------------------------------------------------
+
+//HMACAuthenticator authenticates requests signed with a shared secret. The signature
+//is the hex encoded HMAC-SHA256 of "method\nrequestURI\ntimestamp\nhex(sha256(body))".
+//Requests signed outside the window or replayed within it are rejected.
+type HMACAuthenticator struct {
+	sync.Mutex
+	//MaxBodySize is the size of the bodies buffered to verify their signature, larger
+	//requests are rejected with 413. It is 10MB by default.
+	MaxBodySize int64
+
+	secrets map[string][]byte
+	window  time.Duration
+	seen    map[string]time.Time
+}
+
+//NewHMACAuthenticator returns an authenticator accepting signatures of secrets
+//by their key id, window defaults to 5 minutes
+func NewHMACAuthenticator(secrets map[string]string, window time.Duration) *HMACAuthenticator {
+	if window <= 0 {
+		window = defaultSignatureWindow
+	}
+	a := &HMACAuthenticator{
+		MaxBodySize: defaultMaxSignedBody,
+		secrets:     make(map[string][]byte, len(secrets)),
+		window:      window,
+		seen:        make(map[string]time.Time),
+	}
+	for id, secret := range secrets {
+		a.secrets[id] = []byte(secret)
+	}
+	return a
+}
+
+//SignRequest signs req with the secret, the request body is buffered
+func SignRequest(req *http.Request, keyID string, secret string) error {
+	body, err := readBody(req, 0)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set(SignatureKeyHeader, keyID)
+	req.Header.Set(SignatureTimestampHeader, timestamp)
+	req.Header.Set(SignatureHeader, hex.EncodeToString(
+		signature([]byte(secret), req.Method, req.URL.RequestURI(), timestamp, body)))
+	return nil
+}
+
+//Authenticate implement Authenticator interface
+func (a *HMACAuthenticator) Authenticate(req *http.Request) (*Principal, error) {
+	keyID := req.Header.Get(SignatureKeyHeader)
+	sig := req.Header.Get(SignatureHeader)
+	timestamp := req.Header.Get(SignatureTimestampHeader)
+	if len(keyID) == 0 && len(sig) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	secret, ok := a.secrets[keyID]
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	signedAt := time.Unix(unix, 0)
+	if d := time.Since(signedAt); d > a.window || d < -a.window {
+		return nil, errors.New("request signature is expired")
+	}
+
+	body, err := readBody(req, a.MaxBodySize)
+	if err != nil {
+		return nil, err
+	}
+	got, err := hex.DecodeString(sig)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+	if !hmac.Equal(got, signature(secret, req.Method, req.URL.RequestURI(), timestamp, body)) {
+		return nil, ErrInvalidCredentials
+	}
+
+	if !a.remember(keyID+":"+sig, signedAt) {
+		return nil, errors.New("request signature is replayed")
+	}
+
+	return &Principal{
+		ID:     keyID,
+		Method: "hmac",
+	}, nil
+}
+
+//Challenge implement Challenger interface
+func (a *HMACAuthenticator) Challenge() string {
+	return "HMAC-SHA256"
+}
+
+//remember returns false if the signature has been seen within the window
+func (a *HMACAuthenticator) remember(key string, signedAt time.Time) bool {
+	a.Lock()
+	defer a.Unlock()
+
+	now := time.Now()
+	for k, t := range a.seen {
+		if now.Sub(t) > a.window {
+			delete(a.seen, k)
+		}
+	}
+	if _, ok := a.seen[key]; ok {
+		return false
+	}
+	a.seen[key] = signedAt
+	return true
+}
+
+func signature(secret []byte, method string, uri string, timestamp string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(method + "\n" + uri + "\n" + timestamp + "\n" + hex.EncodeToString(digest[:])))
+	return mac.Sum(nil)
+}
+
+//readBody returns the request body and restores it for the next reader, bodies larger
+//than limit return ErrBodyTooLarge, limit 0 reads the whole body
+func readBody(req *http.Request, limit int64) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	reader := req.Body
+	if limit > 0 {
+		reader = http.MaxBytesReader(nil, req.Body, limit)
+	}
+	body, err := ioutil.ReadAll(reader)
+	req.Body.Close()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return nil, ErrBodyTooLarge
+	}
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+func authorizationValue(req *http.Request, scheme string) string {
+	value := req.Header.Get("Authorization")
+	if len(value) > len(scheme) && strings.EqualFold(value[:len(scheme)], scheme) && value[len(scheme)] == ' ' {
+		return strings.TrimSpace(value[len(scheme)+1:])
+	}
+	return ""
+}
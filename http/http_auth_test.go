@@ -0,0 +1,269 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func signJWT(t *testing.T, header map[string]interface{}, claims map[string]interface{},
+	sign func([]byte) []byte) string {
+	h, _ := json.Marshal(header)
+	c, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("jwt-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "key-1",
+			"n":   base64.RawURLEncoding.EncodeToString(rsaKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(rsaKey.E)).Bytes()),
+		}},
+	})
+	jwksFile, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(jwksFile.Name())
+	jwksFile.Write(jwks)
+	jwksFile.Close()
+
+	jwtAuth, err := NewJWTAuthenticator(JWTConfig{
+		Secret:   secret,
+		JWKSFile: jwksFile.Name(),
+		Issuer:   "orb",
+	})
+	if err != nil {
+		t.Fatalf("NewJWTAuthenticator() error = %v", err)
+	}
+
+	hs256 := func(b []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(b)
+		return mac.Sum(nil)
+	}
+	rs256 := func(b []byte) []byte {
+		digest := sha256.Sum256(b)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		return sig
+	}
+	exp := float64(time.Now().Add(time.Hour).Unix())
+
+	s := NewHTTPServer("localhost", 0, "/api")
+	s.Use(Authenticate(
+		NewAPIKeyAuthenticator([]APIKey{
+			{Key: "admin-key", Name: "admin", Roles: []string{"admin"}},
+			{Key: "reader-key", Name: "reader"},
+		}),
+		jwtAuth,
+		NewHMACAuthenticator(map[string]string{"svc": "hmac-secret"}, time.Minute),
+	))
+	whoami := ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		return GetPrincipal(ctx), 0
+	})
+	s.RegisterApiHandler("/public", whoami)
+	s.RegisterApiHandler("/private", whoami, RequireAuth())
+	s.RegisterApiHandler("/admin", whoami, RequireAuth("admin"))
+
+	tests := []struct {
+		name          string
+		url           string
+		header        map[string]string
+		sign          string
+		wantCode      int
+		wantID        string
+		wantChallenge []string
+	}{
+		{
+			name:     "anonymous public route",
+			url:      "/api/public",
+			wantCode: http.StatusOK,
+		},
+		{
+			name:          "anonymous private route",
+			url:           "/api/private",
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: []string{"ApiKey", "Bearer", "HMAC-SHA256"},
+		},
+		{
+			name:          "invalid api key",
+			url:           "/api/public",
+			header:        map[string]string{APIKeyHeader: "wrong"},
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: []string{"ApiKey"},
+		},
+		{
+			name:     "api key",
+			url:      "/api/admin",
+			header:   map[string]string{APIKeyHeader: "admin-key"},
+			wantCode: http.StatusOK,
+			wantID:   "admin",
+		},
+		{
+			name:     "api key authorization scheme",
+			url:      "/api/private",
+			header:   map[string]string{"Authorization": "ApiKey reader-key"},
+			wantCode: http.StatusOK,
+			wantID:   "reader",
+		},
+		{
+			name:     "api key without role",
+			url:      "/api/admin",
+			header:   map[string]string{APIKeyHeader: "reader-key"},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "HS256 jwt",
+			url:  "/api/admin",
+			header: map[string]string{"Authorization": "Bearer " + signJWT(t,
+				map[string]interface{}{"alg": "HS256"},
+				map[string]interface{}{"sub": "alice", "iss": "orb", "exp": exp, "roles": []string{"admin"}}, hs256)},
+			wantCode: http.StatusOK,
+			wantID:   "alice",
+		},
+		{
+			name: "RS256 jwt",
+			url:  "/api/private",
+			header: map[string]string{"Authorization": "Bearer " + signJWT(t,
+				map[string]interface{}{"alg": "RS256", "kid": "key-1"},
+				map[string]interface{}{"sub": "bob", "iss": "orb", "exp": exp}, rs256)},
+			wantCode: http.StatusOK,
+			wantID:   "bob",
+		},
+		{
+			name: "expired jwt",
+			url:  "/api/private",
+			header: map[string]string{"Authorization": "Bearer " + signJWT(t,
+				map[string]interface{}{"alg": "HS256"},
+				map[string]interface{}{"sub": "alice", "iss": "orb", "exp": float64(time.Now().Add(-time.Hour).Unix())}, hs256)},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "jwt with wrong issuer",
+			url:  "/api/private",
+			header: map[string]string{"Authorization": "Bearer " + signJWT(t,
+				map[string]interface{}{"alg": "HS256"},
+				map[string]interface{}{"sub": "alice", "iss": "other", "exp": exp}, hs256)},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name: "unsigned jwt",
+			url:  "/api/private",
+			header: map[string]string{"Authorization": "Bearer " + signJWT(t,
+				map[string]interface{}{"alg": "none"},
+				map[string]interface{}{"sub": "alice", "iss": "orb", "exp": exp}, func([]byte) []byte { return nil })},
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:     "hmac signed request",
+			url:      "/api/private",
+			sign:     "hmac-secret",
+			wantCode: http.StatusOK,
+			wantID:   "svc",
+		},
+		{
+			name:          "hmac wrong secret",
+			url:           "/api/private",
+			sign:          "wrong-secret",
+			wantCode:      http.StatusUnauthorized,
+			wantChallenge: []string{"HMAC-SHA256"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.url, bytes.NewBufferString(`{"alert":"disk full"}`))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if len(tt.sign) > 0 {
+				if err := SignRequest(req, "svc", tt.sign); err != nil {
+					t.Fatalf("SignRequest() error = %v", err)
+				}
+			}
+			rw := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rw, req)
+
+			if rw.Code != tt.wantCode {
+				t.Fatalf("code = %v, want %v, body %s", rw.Code, tt.wantCode, rw.Body)
+			}
+			if got := rw.Header().Values("WWW-Authenticate"); tt.wantChallenge != nil && !reflect.DeepEqual(got, tt.wantChallenge) {
+				t.Errorf("WWW-Authenticate = %v, want %v", got, tt.wantChallenge)
+			}
+			if tt.wantCode != http.StatusOK {
+				var errRes ErrorResponse
+				if err := json.NewDecoder(rw.Body).Decode(&errRes); err != nil || errRes.Code != tt.wantCode {
+					t.Errorf("error response = %+v, err %v", errRes, err)
+				}
+				return
+			}
+			var principal *Principal
+			json.NewDecoder(rw.Body).Decode(&principal)
+			if (principal == nil && len(tt.wantID) > 0) || (principal != nil && principal.ID != tt.wantID) {
+				t.Errorf("principal = %+v, want %v", principal, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestHMACAuthenticator_Replay(t *testing.T) {
+	a := NewHMACAuthenticator(map[string]string{"svc": "secret"}, time.Minute)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/alerts?chat=ops", bytes.NewBufferString("body"))
+	SignRequest(req, "svc", "secret")
+	if _, err := a.Authenticate(req); err != nil {
+		t.Fatalf("Authenticate() error = %v", err)
+	}
+	if body, _ := ioutil.ReadAll(req.Body); string(body) != "body" {
+		t.Errorf("request body = %s, want body", body)
+	}
+
+	replay := httptest.NewRequest(http.MethodPost, "/api/alerts?chat=ops", bytes.NewBufferString("body"))
+	replay.Header = req.Header.Clone()
+	if _, err := a.Authenticate(replay); err == nil {
+		t.Errorf("Authenticate() replayed request should fail")
+	}
+
+	tampered := httptest.NewRequest(http.MethodPost, "/api/alerts?chat=ops", bytes.NewBufferString("other"))
+	SignRequest(tampered, "svc", "secret")
+	tampered.Body = ioutil.NopCloser(bytes.NewBufferString("changed"))
+	if _, err := a.Authenticate(tampered); err == nil {
+		t.Errorf("Authenticate() tampered body should fail")
+	}
+
+	large := httptest.NewRequest(http.MethodPost, "/api/alerts", bytes.NewBufferString("large body"))
+	SignRequest(large, "svc", "secret")
+	a.MaxBodySize = 4
+	if _, err := a.Authenticate(large); err != ErrBodyTooLarge {
+		t.Errorf("Authenticate() large body error = %v, want ErrBodyTooLarge", err)
+	}
+
+	expired := httptest.NewRequest(http.MethodGet, "/api/alerts", nil)
+	SignRequest(expired, "svc", "secret")
+	expired.Header.Set(SignatureTimestampHeader, strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10))
+	if _, err := a.Authenticate(expired); err == nil {
+		t.Errorf("Authenticate() expired signature should fail")
+	}
+}
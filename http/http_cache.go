@@ -0,0 +1,367 @@
+package http
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultCacheEntries = 1000
+
+//CacheConfig configures the Cache middleware, a zero TTL only adds ETags and
+//conditional request handling without keeping responses in memory
+type CacheConfig struct {
+	//TTL is how long a response is served from memory
+	TTL time.Duration
+	//MaxEntries bounds the LRU of stored responses, 1000 by default
+	MaxEntries int
+	//VaryHeaders are request headers that select different responses besides Accept
+	VaryHeaders []string
+	//CacheControl is sent when the handler does not set it,
+	//by default max-age of the TTL, or no-cache without a TTL
+	CacheControl string
+}
+
+//Cache is a middleware for GET handlers computing an ETag from the encoded response,
+//answering If-None-Match with 304 and optionally serving responses from an in-memory
+//LRU keyed by method, path, query, Accept and VaryHeaders. Concurrent identical requests
+//run the handler only once when its response can be shared. Responses of authenticated
+//requests are keyed by principal, requests carrying credentials before Authenticate
+//ran, websocket upgrades and event streams bypass the cache, and responses flushed by
+//the handler, e.g. NDJSON streams, are written as they are produced and never cached.
+func Cache(c CacheConfig) Middleware {
+	return newCache(c, time.Now)
+}
+
+func newCache(c CacheConfig, now func() time.Time) Middleware {
+	var store *lruCache
+	if c.TTL > 0 {
+		if c.MaxEntries <= 0 {
+			c.MaxEntries = defaultCacheEntries
+		}
+		store = newLRUCache(c.MaxEntries, c.TTL, now)
+	}
+	if len(c.CacheControl) == 0 {
+		c.CacheControl = "no-cache"
+		if c.TTL > 0 {
+			c.CacheControl = "max-age=" + strconv.Itoa(int(c.TTL/time.Second))
+		}
+	}
+	vary := []string{"Accept"}
+	for _, name := range c.VaryHeaders {
+		vary = append(vary, http.CanonicalHeaderKey(name))
+	}
+	group := &flightGroup{}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if (r.Method != http.MethodGet && r.Method != http.MethodHead) || bypassCache(r) {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			key := cacheKey(r, vary)
+			if store != nil && !noCache(r) {
+				if rsp, stored, ok := store.get(key); ok {
+					rw.Header().Set("Age", strconv.Itoa(int(now().Sub(stored)/time.Second)))
+					writeConditional(rw, r, rsp)
+					return
+				}
+			}
+
+			serve := func(rw http.ResponseWriter, call *flightCall) *bufferedResponse {
+				w := &cacheWriter{bufferedWriter: newBufferedWriter(), rw: rw, call: call}
+				next.ServeHTTP(w, r)
+				if w.streaming {
+					return w.streamed()
+				}
+				rsp := w.response()
+				if rsp.status != http.StatusOK {
+					return rsp
+				}
+				if len(rsp.header.Get("ETag")) == 0 {
+					sum := sha256.Sum256(rsp.body)
+					rsp.header.Set("ETag", `"`+hex.EncodeToString(sum[:16])+`"`)
+				}
+				if len(rsp.header.Get("Cache-Control")) == 0 {
+					rsp.header.Set("Cache-Control", c.CacheControl)
+				}
+				for _, name := range vary {
+					if !headerContains(rsp.header, "Vary", name) {
+						rsp.header.Add("Vary", name)
+					}
+				}
+				if store != nil && cacheable(rsp) {
+					store.add(key, rsp)
+				}
+				return rsp
+			}
+			rsp, shared := group.do(key, func(call *flightCall) *bufferedResponse {
+				return serve(rw, call)
+			})
+			//responses setting cookies, private, failed or streamed ones are never handed
+			//to another caller
+			if shared && rsp != nil && !cacheable(rsp) {
+				rsp = serve(rw, nil)
+			}
+			if rsp != nil && rsp.streamed {
+				//already written to rw by the handler
+				return
+			}
+			if rsp == nil {
+				WriteError(rw, http.StatusInternalServerError, "Internal Error")
+				return
+			}
+			writeConditional(rw, r, rsp)
+		})
+	}
+}
+
+//writeConditional writes rsp, or 304 when the client already has its ETag
+func writeConditional(rw http.ResponseWriter, r *http.Request, rsp *bufferedResponse) {
+	etag := rsp.header.Get("ETag")
+	if rsp.status != http.StatusOK || len(etag) == 0 || !etagMatches(r.Header.Get("If-None-Match"), etag) {
+		rsp.writeTo(rw)
+		return
+	}
+
+	header := rw.Header()
+	for _, name := range []string{"ETag", "Cache-Control", "Vary", "Expires", "Last-Modified"} {
+		if values, ok := rsp.header[name]; ok {
+			header[name] = append([]string(nil), values...)
+		}
+	}
+	rw.WriteHeader(http.StatusNotModified)
+}
+
+func cacheKey(r *http.Request, vary []string) string {
+	//HEAD responses have no body, they must not be served to GET requests
+	var b strings.Builder
+	b.WriteString(r.Method + " ")
+	b.WriteString(r.URL.Path)
+	b.WriteString("?")
+	b.WriteString(r.URL.Query().Encode())
+	for _, name := range vary {
+		b.WriteString("\n" + name + ":" + strings.Join(r.Header.Values(name), ","))
+	}
+	//never share the response of one caller with another
+	if principal := GetPrincipal(r.Context()); principal != nil {
+		b.WriteString("\nprincipal:" + principal.Method + ":" + principal.ID)
+	}
+	return b.String()
+}
+
+//bypassCache returns true for the requests the cache must not buffer or share: anonymous
+//requests carrying credentials, e.g. when Authenticate runs as a route middleware,
+//websocket upgrades and event streams, which need to hijack or flush the connection
+func bypassCache(r *http.Request) bool {
+	if GetPrincipal(r.Context()) == nil {
+		for _, name := range []string{"Authorization", APIKeyHeader, SignatureHeader} {
+			if len(r.Header.Get(name)) > 0 {
+				return true
+			}
+		}
+	}
+	return headerContains(r.Header, "Connection", "upgrade") || len(r.Header.Get("Upgrade")) > 0 ||
+		strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+}
+
+func noCache(r *http.Request) bool {
+	cc := r.Header.Get("Cache-Control")
+	return strings.Contains(cc, "no-cache") || strings.Contains(cc, "no-store")
+}
+
+func cacheable(rsp *bufferedResponse) bool {
+	cc := rsp.header.Get("Cache-Control")
+	return rsp.status == http.StatusOK && !rsp.streamed &&
+		!strings.Contains(cc, "no-store") &&
+		!strings.Contains(cc, "private") &&
+		len(rsp.header.Get("Set-Cookie")) == 0
+}
+
+//etagMatches implements the weak comparison of If-None-Match
+func etagMatches(ifNoneMatch string, etag string) bool {
+	if strings.TrimSpace(ifNoneMatch) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+func headerContains(header http.Header, key string, value string) bool {
+	for _, v := range header.Values(key) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//cacheWriter buffers the response until the handler flushes it, e.g. a stream, from then
+//on the response is written to rw as it is produced
+type cacheWriter struct {
+	*bufferedWriter
+	rw        http.ResponseWriter
+	call      *flightCall
+	streaming bool
+}
+
+func (w *cacheWriter) WriteHeader(code int) {
+	if w.streaming {
+		return
+	}
+	w.bufferedWriter.WriteHeader(code)
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.rw.Write(b)
+	}
+	return w.bufferedWriter.Write(b)
+}
+
+//Flush writes the buffered response to rw and switches to streaming, the callers waiting
+//for the same key are released so that they serve the request themselves
+func (w *cacheWriter) Flush() {
+	if !w.streaming {
+		w.streaming = true
+		w.response().writeTo(w.rw)
+		if w.call != nil {
+			w.call.release(w.streamed())
+		}
+	}
+	if flusher, ok := w.rw.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+//streamed returns the response of a streaming handler, already written to rw
+func (w *cacheWriter) streamed() *bufferedResponse {
+	rsp := w.response()
+	return &bufferedResponse{status: rsp.status, header: rsp.header, streamed: true}
+}
+
+// ----------------------------------------------------------------------------
+// LRU
+// ---------------------------------------------------------------------------
+
+type lruEntry struct {
+	key    string
+	rsp    *bufferedResponse
+	stored time.Time
+}
+
+type lruCache struct {
+	sync.Mutex
+	max   int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+	now   func() time.Time
+}
+
+func newLRUCache(max int, ttl time.Duration, now func() time.Time) *lruCache {
+	return &lruCache{
+		max:   max,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+		now:   now,
+	}
+}
+
+func (c *lruCache) get(key string) (*bufferedResponse, time.Time, bool) {
+	c.Lock()
+	defer c.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	entry := el.Value.(*lruEntry)
+	if c.now().Sub(entry.stored) >= c.ttl {
+		c.ll.Remove(el)
+		delete(c.items, key)
+		return nil, time.Time{}, false
+	}
+	c.ll.MoveToFront(el)
+	return entry.rsp, entry.stored, true
+}
+
+func (c *lruCache) add(key string, rsp *bufferedResponse) {
+	c.Lock()
+	defer c.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value = &lruEntry{key: key, rsp: rsp, stored: c.now()}
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, rsp: rsp, stored: c.now()})
+	for c.ll.Len() > c.max {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruEntry).key)
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Single flight
+// ---------------------------------------------------------------------------
+
+type flightCall struct {
+	done chan struct{}
+	once sync.Once
+	rsp  *bufferedResponse
+}
+
+//release hands rsp to the waiting callers, only the first release counts
+func (c *flightCall) release(rsp *bufferedResponse) {
+	c.once.Do(func() {
+		c.rsp = rsp
+		close(c.done)
+	})
+}
+
+//flightGroup runs a function once for concurrent callers of the same key
+type flightGroup struct {
+	sync.Mutex
+	calls map[string]*flightCall
+}
+
+//do returns the response of fn and whether it was run by another caller, the callers
+//waiting on a panicking fn get nil. fn may release the waiting callers before it returns.
+func (g *flightGroup) do(key string, fn func(*flightCall) *bufferedResponse) (*bufferedResponse, bool) {
+	g.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.Unlock()
+		<-call.done
+		return call.rsp, true
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.Unlock()
+
+	defer func() {
+		g.Lock()
+		delete(g.calls, key)
+		g.Unlock()
+		call.release(nil)
+	}()
+	rsp := fn(call)
+	call.release(rsp)
+	return rsp, false
+}
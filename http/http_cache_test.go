@@ -0,0 +1,196 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	var calls int32
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/private" {
+			rw.Header().Set("Cache-Control", "private")
+		}
+		rw.Write([]byte("result of " + r.URL.RawQuery))
+	})
+	h := newCache(CacheConfig{TTL: time.Minute, MaxEntries: 2}, func() time.Time { return now })(handler)
+
+	do := func(method string, url string, header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		header  map[string]string
+		advance time.Duration
+		status  int
+		calls   int32
+	}{
+		{"first request runs handler", "GET", "/search?b=2&a=1", nil, 0, 200, 1},
+		{"same query in other order hits cache", "GET", "/search?a=1&b=2", nil, 0, 200, 1},
+		{"other accept misses", "GET", "/search?a=1&b=2", map[string]string{"Accept": "text/csv"}, 0, 200, 2},
+		{"no-cache request bypasses", "GET", "/search?a=1&b=2", map[string]string{"Cache-Control": "no-cache"}, 0, 200, 3},
+		{"post is not cached", "POST", "/search?a=1&b=2", nil, 0, 200, 4},
+		{"private response is not stored", "GET", "/private", nil, 0, 200, 5},
+		{"private response again", "GET", "/private", nil, 0, 200, 6},
+		{"expired entry runs handler", "GET", "/search?a=1&b=2", nil, time.Minute, 200, 7},
+		{"evicts least recently used", "GET", "/other", nil, 0, 200, 8},
+		{"third key", "GET", "/third", nil, 0, 200, 9},
+		{"evicted entry runs handler", "GET", "/search?a=1&b=2", map[string]string{"Accept": "text/csv"}, 0, 200, 10},
+		{"unauthenticated credentials bypass", "GET", "/third", map[string]string{"Authorization": "Bearer t"}, 0, 200, 11},
+		{"api key bypasses", "GET", "/third", map[string]string{APIKeyHeader: "k"}, 0, 200, 12},
+		{"event stream bypasses", "GET", "/third", map[string]string{"Accept": "text/event-stream"}, 0, 200, 13},
+		{"websocket upgrade bypasses", "GET", "/third", map[string]string{"Connection": "Upgrade", "Upgrade": "websocket"}, 0, 200, 14},
+		{"head is not served the get entry", "HEAD", "/third", nil, 0, 200, 15},
+	}
+	for _, tt := range tests {
+		now = now.Add(tt.advance)
+		rw := do(tt.method, tt.url, tt.header)
+		if rw.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rw.Code, tt.status)
+		}
+		if got := atomic.LoadInt32(&calls); got != tt.calls {
+			t.Errorf("%s: handler calls = %d, want %d", tt.name, got, tt.calls)
+		}
+	}
+}
+
+func TestCache_ConditionalRequest(t *testing.T) {
+	h := Cache(CacheConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("payload"))
+	}))
+
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	etag := rw.Header().Get("ETag")
+	if len(etag) == 0 || rw.Header().Get("Cache-Control") != "no-cache" {
+		t.Fatalf("headers = %v, want ETag and Cache-Control no-cache", rw.Header())
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{"matching etag", etag, http.StatusNotModified},
+		{"weak matching etag", `"other", W/` + etag, http.StatusNotModified},
+		{"any", "*", http.StatusNotModified},
+		{"different etag", `"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Header.Set("If-None-Match", tt.ifNoneMatch)
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, req)
+			if rw.Code != tt.status {
+				t.Errorf("status = %d, want %d", rw.Code, tt.status)
+			}
+			if tt.status == http.StatusNotModified && rw.Body.Len() > 0 {
+				t.Errorf("304 body = %q, want empty", rw.Body.String())
+			}
+		})
+	}
+}
+
+func TestCache_SingleFlight(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := Cache(CacheConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		rw.Write([]byte("slow"))
+	}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			if rw.Body.String() != "slow" {
+				t.Errorf("body = %q, want slow", rw.Body.String())
+			}
+		}()
+	}
+	//let the requests pile up behind the first one
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 1 {
+		t.Errorf("handler calls = %d, want 1", got)
+	}
+}
+
+func TestCache_SingleFlightPrivate(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := Cache(CacheConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		<-release
+		http.SetCookie(rw, &http.Cookie{Name: "session", Value: strconv.Itoa(int(n))})
+		rw.Write([]byte("login"))
+	}))
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	cookies := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rw := httptest.NewRecorder()
+			h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/login", nil))
+			mu.Lock()
+			cookies[rw.Header().Get("Set-Cookie")] = true
+			mu.Unlock()
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if got := atomic.LoadInt32(&calls); got != 3 || len(cookies) != 3 {
+		t.Errorf("handler calls = %d, cookies %v, want a session per caller", got, cookies)
+	}
+}
+
+func TestCache_Stream(t *testing.T) {
+	s := NewHTTPServer("localhost", 0, "")
+	s.Use(Cache(CacheConfig{TTL: time.Minute}))
+	var calls int32
+	s.RegisterStreamHandler("/tail", StreamHandlerFunc(func(ctx context.Context, req *http.Request, stream Stream) error {
+		atomic.AddInt32(&calls, 1)
+		return stream.SendEvent(Event{Data: tailMessage{ChatID: "ops", Content: "disk full"}})
+	}), WithHeartbeat(0))
+
+	for i := 1; i <= 2; i++ {
+		rw := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/tail", nil))
+		if rw.Code != http.StatusOK || !strings.Contains(rw.Body.String(), "disk full") || !rw.Flushed {
+			t.Errorf("response = %d %q flushed %v, want the stream", rw.Code, rw.Body.String(), rw.Flushed)
+		}
+		if got := atomic.LoadInt32(&calls); got != int32(i) {
+			t.Errorf("handler calls = %d, want %d, streams are never cached", got, i)
+		}
+	}
+}
@@ -0,0 +1,374 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	defaultCaptureMaxSize    = 10 << 20
+	defaultCaptureMaxBackups = 3
+	defaultCaptureBodies     = 64 << 10
+)
+
+//ErrIncompleteCapture is returned by NewRequest when the request body was not recorded
+//as it was sent, e.g. it was truncated or could not be redacted
+var ErrIncompleteCapture = errors.New("request body was not recorded as it was sent")
+
+//CaptureConfig configures the Capture middleware
+type CaptureConfig struct {
+	//Path is the capture file, it is rotated to Path.1, Path.2, etc. when it exceeds MaxSize
+	Path string
+	//MaxSize is 10MB by default
+	MaxSize int64
+	//MaxBackups is the number of rotated files kept, 3 by default
+	MaxBackups int
+	//SampleRate is the fraction of requests considered, 1 by default
+	SampleRate float64
+	//Filter selects the requests recorded by the request and the response status, all by default
+	Filter func(r *http.Request, status int) bool
+	//RedactHeaders are recorded as [REDACTED], credentials headers by default
+	RedactHeaders []string
+	//RedactQuery are query parameters and form fields recorded as [REDACTED], access_token, token, etc. by default
+	RedactQuery []string
+	//RedactFields are the substrings of the JSON body fields recorded as [REDACTED],
+	//password, secret, token, key, credential and private by default
+	RedactFields []string
+	//MaxBodySize is the size of the recorded bodies, 64KB by default
+	MaxBodySize int
+}
+
+//Capture is an opt-in debugging middleware recording request and response pairs to a
+//rotating file, one HAR entry per line. Credentials are redacted, so replayed requests
+//need them to be set again, see httptest.Replay.
+func Capture(c CaptureConfig) Middleware {
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultCaptureMaxSize
+	}
+	if c.MaxBackups <= 0 {
+		c.MaxBackups = defaultCaptureMaxBackups
+	}
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		c.SampleRate = 1
+	}
+	if c.RedactHeaders == nil {
+		c.RedactHeaders = defaultRedactHeaders
+	}
+	if c.RedactQuery == nil {
+		c.RedactQuery = defaultRedactQuery
+	}
+	if c.RedactFields == nil {
+		c.RedactFields = defaultRedactKeys
+	}
+	if c.MaxBodySize <= 0 {
+		c.MaxBodySize = defaultCaptureBodies
+	}
+	w := &captureWriter{config: c}
+	redactor := &accessLogger{config: AccessLogConfig{RedactHeaders: c.RedactHeaders, RedactQuery: c.RedactQuery}}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if c.SampleRate < 1 && rand.Float64() >= c.SampleRate {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			start := time.Now()
+			var requestBody []byte
+			if r.Body != nil {
+				requestBody, _ = ioutil.ReadAll(io.LimitReader(r.Body, int64(c.MaxBodySize)+1))
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{io.MultiReader(bytes.NewReader(requestBody), r.Body), r.Body}
+			}
+
+			rec := &accessLogRecorder{responseRecorder: newResponseRecorder(rw), limit: c.MaxBodySize + 1}
+			next.ServeHTTP(rec, r)
+
+			if c.Filter != nil && !c.Filter(r, rec.status) {
+				return
+			}
+			entry := c.entry(redactor, r, requestBody, rec, start)
+			if err := w.write(entry); err != nil {
+				logging.Error("capture request failed", logging.Fields{
+					"path": c.Path,
+				}, err)
+			}
+		})
+	}
+}
+
+// ----------------------------------------------------------------------------
+// HAR entries
+// ---------------------------------------------------------------------------
+
+//HAREntry is a recorded request and response in the HAR 1.2 entry format
+type HAREntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         HARRequest  `json:"request"`
+	Response        HARResponse `json:"response"`
+}
+
+//HARRequest is the request of a HAREntry
+type HARRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	QueryString []HARNameValue `json:"queryString"`
+	PostData    *HARPostData   `json:"postData,omitempty"`
+	BodySize    int            `json:"bodySize"`
+}
+
+//HARResponse is the response of a HAREntry
+type HARResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Headers     []HARNameValue `json:"headers"`
+	Content     HARContent     `json:"content"`
+	BodySize    int            `json:"bodySize"`
+}
+
+//HARNameValue is a header or query parameter
+type HARNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+//HARPostData is a request body
+type HARPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+//HARContent is a response body
+type HARContent struct {
+	Size     int    `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	Encoding string `json:"encoding,omitempty"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+//NewRequest returns the recorded request, e.g. to serve it again. It returns
+//ErrIncompleteCapture if the body was truncated or could not be redacted.
+func (e *HAREntry) NewRequest() (*http.Request, error) {
+	var body io.Reader = http.NoBody
+	if data := e.Request.PostData; data != nil {
+		if data.Comment != "" {
+			return nil, fmt.Errorf("%w: %s", ErrIncompleteCapture, data.Comment)
+		}
+		body = strings.NewReader(data.Text)
+		if data.Encoding == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(data.Text)
+			if err != nil {
+				return nil, err
+			}
+			body = bytes.NewReader(decoded)
+		}
+	}
+	req, err := http.NewRequest(e.Request.Method, e.Request.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	for _, h := range e.Request.Headers {
+		req.Header.Add(h.Name, h.Value)
+	}
+	req.RequestURI = req.URL.RequestURI()
+	return req, nil
+}
+
+//ReadCapture reads the entries of a file written by Capture
+func ReadCapture(path string) ([]HAREntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []HAREntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64<<10), 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry HAREntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+func (c CaptureConfig) entry(redactor *accessLogger, r *http.Request, requestBody []byte,
+	rec *accessLogRecorder, start time.Time) *HAREntry {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	entry := &HAREntry{
+		StartedDateTime: start,
+		Time:            float64(time.Since(start)) / float64(time.Millisecond),
+		Request: HARRequest{
+			Method:      r.Method,
+			URL:         scheme + "://" + r.Host + redactor.redactURL(r.URL),
+			HTTPVersion: r.Proto,
+			Headers:     c.headers(redactor, r.Header),
+			QueryString: c.queryString(redactor, r.URL.Query()),
+			BodySize:    len(requestBody),
+		},
+		Response: HARResponse{
+			Status:      rec.status,
+			StatusText:  http.StatusText(rec.status),
+			HTTPVersion: r.Proto,
+			Headers:     c.headers(redactor, rec.Header()),
+			BodySize:    rec.size,
+		},
+	}
+
+	if len(requestBody) > 0 {
+		mimeType := r.Header.Get("Content-Type")
+		text, encoding, comment := c.body(redactor, mimeType, requestBody)
+		entry.Request.PostData = &HARPostData{MimeType: mimeType, Text: text, Encoding: encoding, Comment: comment}
+	}
+	mimeType := rec.Header().Get("Content-Type")
+	text, encoding, comment := c.body(redactor, mimeType, rec.body)
+	entry.Response.Content = HARContent{Size: rec.size, MimeType: mimeType, Text: text, Encoding: encoding,
+		Comment: comment}
+	return entry
+}
+
+func (c CaptureConfig) headers(redactor *accessLogger, header http.Header) []HARNameValue {
+	headers := make([]HARNameValue, 0, len(header))
+	for name, values := range header {
+		for _, value := range values {
+			if redactor.redactHeader(name) {
+				value = redacted
+			}
+			headers = append(headers, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return headers
+}
+
+func (c CaptureConfig) queryString(redactor *accessLogger, query url.Values) []HARNameValue {
+	redactedQuery, _ := url.ParseQuery(redactor.redactQuery(query))
+	params := make([]HARNameValue, 0, len(redactedQuery))
+	for name, values := range redactedQuery {
+		for _, value := range values {
+			params = append(params, HARNameValue{Name: name, Value: value})
+		}
+	}
+	return params
+}
+
+//body returns the text, encoding and comment of body with the secret JSON fields and
+//form fields redacted, bodies that cannot be redacted, e.g. truncated JSON, are not
+//recorded and binary bodies are base64 encoded
+func (c CaptureConfig) body(redactor *accessLogger, contentType string, body []byte) (string, string, string) {
+	truncated := len(body) > c.MaxBodySize
+	if truncated {
+		body = body[:c.MaxBodySize]
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		//numbers are kept as json.Number so that large ids do not lose precision
+		var v interface{}
+		decoder := json.NewDecoder(bytes.NewReader(body))
+		decoder.UseNumber()
+		if err := decoder.Decode(&v); err != nil {
+			return redacted, "", "body could not be parsed to be redacted"
+		}
+		data, _ := json.Marshal(redactValue(v, c.RedactFields))
+		return string(data), "", ""
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil || truncated {
+			return redacted, "", "body could not be parsed to be redacted"
+		}
+		return redactor.redactQuery(form), "", ""
+	}
+
+	text, encoding, comment := string(body), "", ""
+	if !utf8.Valid(body) {
+		text, encoding = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+	if truncated {
+		comment = "body truncated"
+	}
+	return text, encoding, comment
+}
+
+// ----------------------------------------------------------------------------
+// Rotating file
+// ---------------------------------------------------------------------------
+
+type captureWriter struct {
+	config CaptureConfig
+	mu     sync.Mutex
+}
+
+//write appends entry as a line, the file is opened per entry so that it can be
+//removed or rotated by other tools while the server runs
+func (w *captureWriter) write(entry *HAREntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if info, err := os.Stat(w.config.Path); err == nil && info.Size()+int64(len(line)) > w.config.MaxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(w.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//rotate renames Path to Path.1 after shifting the older backups, the oldest is removed
+func (w *captureWriter) rotate() error {
+	path := w.config.Path
+	os.Remove(fmt.Sprintf("%s.%d", path, w.config.MaxBackups))
+	for i := w.config.MaxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(path, path+".1")
+}
@@ -0,0 +1,164 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCapture(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture.har")
+
+	capture := Capture(CaptureConfig{
+		Path: path,
+		Filter: func(r *http.Request, status int) bool {
+			return status >= http.StatusBadRequest
+		},
+	})
+	handler := capture(ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		var body struct {
+			Name string `json:"name"`
+		}
+		json.NewDecoder(req.Body).Decode(&body)
+		if body.Name != "cpu" {
+			return &ErrorResponse{Code: http.StatusBadRequest, Message: "unknown alert " + body.Name}, 0
+		}
+		return map[string]string{"name": body.Name, "token": "t-123"}, 0
+	}))
+
+	for _, name := range []string{"cpu", "disk"} {
+		req := httptest.NewRequest(http.MethodPost, "/alerts?token=s3cr3t&state=open",
+			strings.NewReader(`{"name":"`+name+`","password":"hunter2"}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer abc")
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		if name == "cpu" && rw.Body.String() != `{"name":"cpu","token":"t-123"}`+"\n" {
+			t.Errorf("body = %s, want the handler to read the captured request body", rw.Body.String())
+		}
+	}
+
+	entries, err := ReadCapture(path)
+	if err != nil {
+		t.Fatalf("ReadCapture() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("entries = %d, want only the filtered 400 response", len(entries))
+	}
+	entry := entries[0]
+	if entry.Request.URL != "http://example.com/alerts?state=open&token=[REDACTED]" {
+		t.Errorf("url = %s, want the token redacted", entry.Request.URL)
+	}
+	for _, h := range entry.Request.Headers {
+		if h.Name == "Authorization" && h.Value != redacted {
+			t.Errorf("Authorization = %s, want it redacted", h.Value)
+		}
+	}
+	if entry.Request.PostData == nil || entry.Request.PostData.Text != `{"name":"disk","password":"[REDACTED]"}` {
+		t.Errorf("postData = %+v, want the password redacted", entry.Request.PostData)
+	}
+	if entry.Response.Status != http.StatusBadRequest ||
+		entry.Response.Content.Text != `{"code":400,"message":"unknown alert disk"}` {
+		t.Errorf("response = %+v", entry.Response)
+	}
+
+	req, err := entry.NewRequest()
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	body, _ := ioutil.ReadAll(req.Body)
+	if req.Method != http.MethodPost || req.URL.Query().Get("state") != "open" || string(body) != entry.Request.PostData.Text {
+		t.Errorf("NewRequest() = %s %s %s", req.Method, req.URL, body)
+	}
+}
+
+func TestCapture_Bodies(t *testing.T) {
+	c := CaptureConfig{MaxBodySize: 32, RedactFields: []string{"password"}}
+	redactor := &accessLogger{}
+	tests := []struct {
+		name         string
+		contentType  string
+		body         []byte
+		wantText     string
+		wantEncoding string
+		wantComment  string
+	}{
+		{"large number", "application/json", []byte(`{"id":12345678901234567}`), `{"id":12345678901234567}`, "", ""},
+		{"truncated json", "application/json", []byte(`{"name":"cpu","password":"hunter2"}`), redacted, "",
+			"body could not be parsed to be redacted"},
+		{"text", "text/plain", []byte("cpu"), "cpu", "", ""},
+		{"truncated text", "text/plain", []byte(strings.Repeat("a", 40)), strings.Repeat("a", 32), "", "body truncated"},
+		{"binary", "application/octet-stream", []byte{0xff, 0x00, 0xfe}, "/wD+", "base64", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, encoding, comment := c.body(redactor, tt.contentType, tt.body)
+			if text != tt.wantText || encoding != tt.wantEncoding || comment != tt.wantComment {
+				t.Errorf("body() = %q, %q, %q, want %q, %q, %q", text, encoding, comment,
+					tt.wantText, tt.wantEncoding, tt.wantComment)
+			}
+		})
+	}
+}
+
+func TestHAREntry_NewRequest(t *testing.T) {
+	entry := HAREntry{Request: HARRequest{Method: http.MethodPost, URL: "http://example.com/upload",
+		PostData: &HARPostData{Text: "/wD+", Encoding: "base64"}}}
+	req, err := entry.NewRequest()
+	if err != nil {
+		t.Fatalf("NewRequest() error = %v", err)
+	}
+	if body, _ := ioutil.ReadAll(req.Body); !bytes.Equal(body, []byte{0xff, 0x00, 0xfe}) {
+		t.Errorf("body = %v, want the base64 text decoded", body)
+	}
+
+	entry.Request.PostData = &HARPostData{Text: redacted, Comment: "body could not be parsed to be redacted"}
+	if _, err := entry.NewRequest(); !errors.Is(err, ErrIncompleteCapture) {
+		t.Errorf("NewRequest() error = %v, want ErrIncompleteCapture", err)
+	}
+}
+
+func TestCapture_Rotate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "capture")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture.har")
+
+	handler := Capture(CaptureConfig{Path: path, MaxSize: 1024, MaxBackups: 2})(
+		http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			rw.Write([]byte(strings.Repeat("a", 600)))
+		}))
+	for i := 0; i < 5; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, fmt.Sprintf("/alerts/%d", i), nil))
+	}
+
+	//every entry exceeds half of MaxSize, so each one starts a new file
+	for i, name := range []string{"capture.har", "capture.har.1", "capture.har.2"} {
+		entries, err := ReadCapture(filepath.Join(dir, name))
+		if err != nil || len(entries) != 1 {
+			t.Fatalf("ReadCapture(%s) = %d entries, %v", name, len(entries), err)
+		}
+		if want := fmt.Sprintf("http://example.com/alerts/%d", 4-i); entries[0].Request.URL != want {
+			t.Errorf("%s url = %s, want %s", name, entries[0].Request.URL, want)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("stat %s.3 error = %v, want only 2 backups", path, err)
+	}
+}
@@ -0,0 +1,518 @@
+package http
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/v-zhidu/orb/logging"
+	"github.com/vmihailenco/msgpack/v5"
+	yaml "gopkg.in/yaml.v2"
+)
+
+//ErrUnsupportedValue is returned by an Encoder that can not represent the value,
+//e.g. CSV for a single object, the next acceptable encoder is tried
+var ErrUnsupportedValue = errors.New("value is not supported by the encoder")
+
+//Encoder writes the response of an ApiHandler in a media type
+type Encoder interface {
+	//Name is the short name selected by the ?format= query parameter
+	Name() string
+	//MediaType is matched against the Accept header and set as Content-Type
+	MediaType() string
+	Encode(w io.Writer, req *http.Request, v interface{}) error
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   = []Encoder{
+		jsonEncoder{},
+		xmlEncoder{},
+		yamlEncoder{},
+		csvEncoder{},
+		msgpackEncoder{},
+	}
+)
+
+//RegisterEncoder adds an encoder, or replaces the encoder of the same media type
+func RegisterEncoder(encoder Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	for i, e := range encoders {
+		if e.MediaType() == encoder.MediaType() {
+			encoders[i] = encoder
+			return
+		}
+	}
+	encoders = append(encoders, encoder)
+}
+
+// ----------------------------------------------------------------------------
+// Raw responses
+// ---------------------------------------------------------------------------
+
+//RawResponse is written as is instead of being encoded
+type RawResponse struct {
+	ContentType string
+	Body        []byte
+}
+
+//FileResponse serves a file with range and conditional request support,
+//Name sets an attachment Content-Disposition when it is not empty
+type FileResponse struct {
+	Path string
+	Name string
+}
+
+//RedirectResponse redirects the client, Status defaults to 302
+type RedirectResponse struct {
+	URL    string
+	Status int
+}
+
+//writeResponse writes rsp of an ApiHandler with the encoder negotiated by the request
+func writeResponse(rw http.ResponseWriter, r *http.Request, rsp interface{}) {
+	switch v := rsp.(type) {
+	case *RawResponse:
+		writeRaw(rw, v)
+		return
+	case RawResponse:
+		writeRaw(rw, &v)
+		return
+	case *FileResponse:
+		writeFileResponse(rw, r, v)
+		return
+	case FileResponse:
+		writeFileResponse(rw, r, &v)
+		return
+	case *RedirectResponse:
+		writeRedirect(rw, r, v)
+		return
+	case RedirectResponse:
+		writeRedirect(rw, r, &v)
+		return
+	case StatusError:
+		WriteError(rw, v.StatusCode(), v.Error())
+		return
+	}
+
+	rw.Header().Add("Vary", "Accept")
+	candidates := negotiate(r)
+	for _, encoder := range candidates {
+		var buf bytes.Buffer
+		err := encoder.Encode(&buf, r, rsp)
+		if err == ErrUnsupportedValue {
+			continue
+		}
+		if err != nil {
+			logging.Error("encode response failed", logging.Fields{
+				"url":       r.RequestURI,
+				"mediaType": encoder.MediaType(),
+			}, err)
+			WriteError(rw, http.StatusInternalServerError, "Internal Error")
+			return
+		}
+
+		if len(rw.Header().Get("Content-Type")) == 0 {
+			rw.Header().Set("Content-Type", encoder.MediaType())
+		}
+		rw.Write(buf.Bytes())
+		return
+	}
+
+	WriteError(rw, http.StatusNotAcceptable, "none of the accepted media types can represent the response")
+}
+
+func writeRaw(rw http.ResponseWriter, raw *RawResponse) {
+	if len(raw.ContentType) > 0 {
+		rw.Header().Set("Content-Type", raw.ContentType)
+	}
+	rw.Write(raw.Body)
+}
+
+func writeFileResponse(rw http.ResponseWriter, r *http.Request, file *FileResponse) {
+	f, err := os.Open(file.Path)
+	if err != nil {
+		WriteError(rw, http.StatusNotFound, "file not found")
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		WriteError(rw, http.StatusNotFound, "file not found")
+		return
+	}
+
+	if len(file.Name) > 0 {
+		rw.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", file.Name))
+	}
+	http.ServeContent(rw, r, filepath.Base(file.Path), info.ModTime(), f)
+}
+
+func writeRedirect(rw http.ResponseWriter, r *http.Request, redirect *RedirectResponse) {
+	status := redirect.Status
+	if status == 0 {
+		status = http.StatusFound
+	}
+	http.Redirect(rw, r, redirect.URL, status)
+}
+
+// ----------------------------------------------------------------------------
+// Negotiation
+// ---------------------------------------------------------------------------
+
+type acceptRange struct {
+	mediaType string
+	q         float64
+	order     int
+}
+
+//negotiate returns the encoders acceptable for the request by preference,
+//?format= takes precedence over the Accept header. JSON stays the default: it is
+//served for */* and wildcard-only matches, other formats go first only when named
+//with a higher q than JSON, or with q=1 when JSON is not named, e.g. not for the
+//application/xml;q=0.9 of browsers.
+func negotiate(r *http.Request) []Encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+
+	if format := r.URL.Query().Get("format"); len(format) > 0 {
+		for _, encoder := range encoders {
+			if encoder.Name() == format {
+				return []Encoder{encoder}
+			}
+		}
+		return nil
+	}
+
+	accept := r.Header.Get("Accept")
+	if len(strings.TrimSpace(accept)) == 0 {
+		return []Encoder{encoders[0]}
+	}
+
+	ranges := parseAccept(accept)
+	var candidates []Encoder
+	seen := make([]bool, len(encoders))
+	for _, ar := range ranges {
+		if ar.q <= 0 {
+			continue
+		}
+		for i, encoder := range encoders {
+			if !seen[i] && mediaTypeMatches(ar.mediaType, encoder.MediaType()) && !excluded(ranges, encoder) {
+				seen[i] = true
+				candidates = append(candidates, encoder)
+			}
+		}
+	}
+	return preferJSON(ranges, candidates)
+}
+
+//preferJSON moves JSON before the candidates the client did not explicitly prefer to it
+func preferJSON(ranges []acceptRange, candidates []Encoder) []Encoder {
+	defaultEncoder := encoders[0]
+	jsonAcceptable := false
+	for _, encoder := range candidates {
+		jsonAcceptable = jsonAcceptable || encoder.Name() == defaultEncoder.Name()
+	}
+	if !jsonAcceptable {
+		return candidates
+	}
+
+	jsonQ, jsonNamed := explicitQuality(ranges, defaultEncoder)
+	var preferred, rest []Encoder
+	for _, encoder := range candidates {
+		if encoder.Name() == defaultEncoder.Name() {
+			continue
+		}
+		q, named := explicitQuality(ranges, encoder)
+		if named && (jsonNamed && q > jsonQ || !jsonNamed && q >= 1) {
+			preferred = append(preferred, encoder)
+		} else {
+			rest = append(rest, encoder)
+		}
+	}
+	return append(append(preferred, defaultEncoder), rest...)
+}
+
+//explicitQuality returns the q of the range naming the media type of encoder
+func explicitQuality(ranges []acceptRange, encoder Encoder) (float64, bool) {
+	for _, ar := range ranges {
+		if ar.mediaType == encoder.MediaType() {
+			return ar.q, true
+		}
+	}
+	return 0, false
+}
+
+func parseAccept(accept string) []acceptRange {
+	var ranges []acceptRange
+	for i, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		ar := acceptRange{
+			mediaType: strings.ToLower(strings.TrimSpace(fields[0])),
+			q:         1,
+			order:     i,
+		}
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && kv[0] == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					ar.q = q
+				}
+			}
+		}
+		if len(ar.mediaType) > 0 {
+			ranges = append(ranges, ar)
+		}
+	}
+
+	//higher quality first, then more specific ranges, then the order of the header
+	sort.SliceStable(ranges, func(i, j int) bool {
+		if ranges[i].q != ranges[j].q {
+			return ranges[i].q > ranges[j].q
+		}
+		return strings.Count(ranges[i].mediaType, "*") < strings.Count(ranges[j].mediaType, "*")
+	})
+	return ranges
+}
+
+func mediaTypeMatches(pattern string, mediaType string) bool {
+	if pattern == "*/*" || pattern == mediaType {
+		return true
+	}
+	if strings.HasSuffix(pattern, "/*") {
+		return strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*"))
+	}
+	return false
+}
+
+//excluded returns true if the exact media type of the encoder is refused with q=0
+func excluded(ranges []acceptRange, encoder Encoder) bool {
+	for _, ar := range ranges {
+		if ar.q <= 0 && ar.mediaType == encoder.MediaType() {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Encoders
+// ---------------------------------------------------------------------------
+
+type jsonEncoder struct{}
+
+func (jsonEncoder) Name() string      { return "json" }
+func (jsonEncoder) MediaType() string { return "application/json" }
+
+//Encode writes indented JSON when the request has a ?pretty parameter
+func (jsonEncoder) Encode(w io.Writer, req *http.Request, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	if _, pretty := req.URL.Query()["pretty"]; pretty {
+		encoder.SetIndent("", "  ")
+	}
+	return encoder.Encode(v)
+}
+
+type xmlEncoder struct{}
+
+func (xmlEncoder) Name() string      { return "xml" }
+func (xmlEncoder) MediaType() string { return "application/xml" }
+
+func (xmlEncoder) Encode(w io.Writer, req *http.Request, v interface{}) error {
+	if v == nil {
+		return ErrUnsupportedValue
+	}
+	data, err := xml.Marshal(v)
+	if err != nil {
+		//maps and interface values can not be represented
+		if _, ok := err.(*xml.UnsupportedTypeError); ok {
+			return ErrUnsupportedValue
+		}
+		return err
+	}
+	io.WriteString(w, xml.Header)
+	_, err = w.Write(data)
+	return err
+}
+
+type yamlEncoder struct{}
+
+func (yamlEncoder) Name() string      { return "yaml" }
+func (yamlEncoder) MediaType() string { return "application/yaml" }
+
+//Encode converts v through JSON first so that json tags name the keys
+func (yamlEncoder) Encode(w io.Writer, req *http.Request, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var generic yaml.MapSlice
+	var value interface{} = &generic
+	if err := yaml.Unmarshal(data, value); err != nil {
+		//not an object, decode as a sequence or scalar
+		var other interface{}
+		if err := yaml.Unmarshal(data, &other); err != nil {
+			return err
+		}
+		value = other
+	}
+	out, err := yaml.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+type msgpackEncoder struct{}
+
+func (msgpackEncoder) Name() string      { return "msgpack" }
+func (msgpackEncoder) MediaType() string { return "application/msgpack" }
+
+func (msgpackEncoder) Encode(w io.Writer, req *http.Request, v interface{}) error {
+	encoder := msgpack.NewEncoder(w)
+	encoder.SetCustomStructTag("json")
+	return encoder.Encode(v)
+}
+
+type csvEncoder struct{}
+
+func (csvEncoder) Name() string      { return "csv" }
+func (csvEncoder) MediaType() string { return "text/csv" }
+
+//Encode writes a header row and a row per element of a slice, the columns are
+//the JSON keys of the elements, nested values are written as JSON
+func (csvEncoder) Encode(w io.Writer, req *http.Request, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return ErrUnsupportedValue
+	}
+
+	//normalise the elements through JSON so that json tags and marshalers apply
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	var rows []interface{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&rows); err != nil {
+		return err
+	}
+
+	columns := csvColumns(rv.Type().Elem(), rows)
+	writer := csv.NewWriter(w)
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = csvText(column)
+	}
+	writer.Write(header)
+	for _, row := range rows {
+		record := make([]string, len(columns))
+		object, isObject := row.(map[string]interface{})
+		for i, column := range columns {
+			if isObject {
+				record[i] = csvValue(object[column])
+			} else {
+				record[i] = csvValue(row)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+//csvColumns returns the struct field order for struct elements, or the sorted union of keys
+func csvColumns(elem reflect.Type, rows []interface{}) []string {
+	for elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	if elem.Kind() == reflect.Struct {
+		return structColumns(elem)
+	}
+
+	keys := make(map[string]bool)
+	for _, row := range rows {
+		object, ok := row.(map[string]interface{})
+		if !ok {
+			return []string{"value"}
+		}
+		for key := range object {
+			keys[key] = true
+		}
+	}
+	columns := make([]string, 0, len(keys))
+	for key := range keys {
+		columns = append(columns, key)
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+//structColumns returns the JSON keys of a struct in field order, embedded structs are flattened
+func structColumns(t reflect.Type) []string {
+	var columns []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && len(name) == 0 && ft.Kind() == reflect.Struct {
+			columns = append(columns, structColumns(ft)...)
+			continue
+		}
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+func csvValue(v interface{}) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return csvText(value)
+	case json.Number:
+		return value.String()
+	case bool:
+		return strconv.FormatBool(value)
+	default:
+		data, _ := json.Marshal(value)
+		return csvText(string(data))
+	}
+}
+
+//csvText prefixes text starting like a formula with a quote so that spreadsheets
+//display it rather than evaluate it, numbers are written by csvValue unchanged
+func csvText(text string) string {
+	if len(text) > 0 && strings.ContainsRune("=+-@\t\r", rune(text[0])) {
+		return "'" + text
+	}
+	return text
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+type encodedBase struct {
+	ID int `json:"id"`
+}
+
+type encodedPoint struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type encodedItem struct {
+	encodedBase
+	Name   string            `json:"name"`
+	Tags   []string          `json:"tags"`
+	Hidden string            `json:"-"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+func TestApiHandler_ContentNegotiation(t *testing.T) {
+	items := []encodedItem{
+		{encodedBase: encodedBase{ID: 1}, Name: "a,b", Tags: []string{"x"}},
+		{encodedBase: encodedBase{ID: 2}, Name: "c"},
+	}
+
+	tests := []struct {
+		name        string
+		rsp         interface{}
+		url         string
+		accept      string
+		status      int
+		contentType string
+		body        string
+	}{
+		{"default json", items[1], "/", "", 200, "application/json", `{"id":2,"name":"c","tags":null}` + "\n"},
+		{"pretty json", map[string]int{"a": 1}, "/?pretty", "", 200, "application/json", "{\n  \"a\": 1\n}\n"},
+		{"accept xml", encodedPoint{1, 2}, "/", "application/xml", 200, "application/xml", "<encodedPoint><X>1</X><Y>2</Y></encodedPoint>"},
+		{"xml of map falls back", items[1], "/", "application/xml, application/yaml;q=0.5", 200, "application/yaml", "id: 2\nname: c\ntags: null\n"},
+		{"accept yaml", items[1], "/", "application/yaml", 200, "application/yaml", "id: 2\nname: c\ntags: null\n"},
+		{"accept csv", items, "/", "text/csv", 200, "text/csv", "id,name,tags,labels\n1,\"a,b\",\"[\"\"x\"\"]\",\n2,c,,\n"},
+		{"csv formulas", []map[string]interface{}{{"=cmd": "=1+2", "n": -3, "s": "-3", "t": "@SUM(A1)"}}, "/", "text/csv", 200, "text/csv",
+			"'=cmd,n,s,t\n'=1+2,-3,'-3,'@SUM(A1)\n"},
+		{"format overrides accept", []map[string]int{{"b": 2, "a": 1}}, "/?format=csv", "application/json", 200, "text/csv", "a,b\n1,2\n"},
+		{"quality order", items[1], "/", "application/json;q=0.5, application/yaml", 200, "application/yaml", "id: 2\nname: c\ntags: null\n"},
+		{"wildcard", items[1], "/", "text/html, */*;q=0.1", 200, "application/json", `{"id":2,"name":"c","tags":null}` + "\n"},
+		{"browser accept", items[1], "/", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8", 200,
+			"application/json", `{"id":2,"name":"c","tags":null}` + "\n"},
+		{"named format before wildcard", items, "/", "text/csv, */*;q=0.1", 200, "text/csv", "id,name,tags,labels\n1,\"a,b\",\"[\"\"x\"\"]\",\n2,c,,\n"},
+		{"named json wins a tie", encodedPoint{1, 2}, "/", "application/xml, application/json", 200, "application/json", `{"x":1,"y":2}` + "\n"},
+		{"excluded json", encodedPoint{1, 2}, "/", "*/*, application/json;q=0", 200, "application/xml", "<encodedPoint><X>1</X><Y>2</Y></encodedPoint>"},
+		{"csv of object falls back", items[1], "/", "text/csv, application/json;q=0.5", 200, "application/json", `{"id":2,"name":"c","tags":null}` + "\n"},
+		{"csv of object", items[1], "/", "text/csv", 406, "application/json", ""},
+		{"unknown format", items, "/?format=toml", "", 406, "application/json", ""},
+		{"unknown accept", items, "/", "text/html", 406, "application/json", ""},
+		{"raw", RawResponse{ContentType: "text/plain", Body: []byte("pong")}, "/", "application/json", 200, "text/plain", "pong"},
+		{"redirect", &RedirectResponse{URL: "/other"}, "/", "", 302, "text/html; charset=utf-8", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rsp := tt.rsp
+			handler := ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+				return rsp, 0
+			})
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			if len(tt.accept) > 0 {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, req)
+
+			if rw.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body %s", rw.Code, tt.status, rw.Body.String())
+			}
+			if got := rw.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			body := strings.TrimPrefix(rw.Body.String(), `<?xml version="1.0" encoding="UTF-8"?>`+"\n")
+			if len(tt.body) > 0 && body != tt.body {
+				t.Errorf("body = %q, want %q", body, tt.body)
+			}
+		})
+	}
+}
+
+func TestApiHandler_Msgpack(t *testing.T) {
+	handler := ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		return encodedItem{encodedBase: encodedBase{ID: 7}, Name: "n"}, 0
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Accept", "application/msgpack")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+
+	var got map[string]interface{}
+	if err := msgpack.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+		t.Fatalf("msgpack decode error = %v", err)
+	}
+	if got["name"] != "n" {
+		t.Errorf("decoded = %v, want name n", got)
+	}
+}
+
+func TestApiHandler_FileResponse(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orb-file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "report.txt")
+	if err := ioutil.WriteFile(path, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		return FileResponse{Path: path, Name: "report.txt"}, 0
+	})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Range", "bytes=2-4")
+	rw := httptest.NewRecorder()
+	handler.ServeHTTP(rw, req)
+
+	if rw.Code != http.StatusPartialContent || rw.Body.String() != "234" {
+		t.Errorf("range response = %d %q, want 206 \"234\"", rw.Code, rw.Body.String())
+	}
+	if got := rw.Header().Get("Content-Disposition"); got != `attachment; filename="report.txt"` {
+		t.Errorf("Content-Disposition = %q", got)
+	}
+}
@@ -0,0 +1,44 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+//ErrorResponse is the JSON envelope of errors returned by the server itself,
+//e.g. authentication failures. An ApiHandler returning a *ErrorResponse answers
+//with the status Code.
+type ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ErrorResponse) Error() string {
+	return e.Message
+}
+
+//StatusCode implement StatusError interface
+func (e *ErrorResponse) StatusCode() int {
+	return e.Code
+}
+
+//StatusError is an error the client has to fix or retry, e.g. *ErrorResponse and
+//*UploadError. An ApiHandler returning it answers with an ErrorResponse of its status.
+type StatusError interface {
+	error
+	StatusCode() int
+}
+
+//WriteError writes an ErrorResponse with the http status code
+func WriteError(rw http.ResponseWriter, status int, message string) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.Header().Set("X-Content-Type-Options", "nosniff")
+	rw.WriteHeader(status)
+
+	encoder := json.NewEncoder(rw)
+	encoder.SetEscapeHTML(false)
+	encoder.Encode(ErrorResponse{
+		Code:    status,
+		Message: message,
+	})
+}
@@ -0,0 +1,320 @@
+package http
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	//IdempotencyKeyHeader is sent by clients to make a request safe to retry
+	IdempotencyKeyHeader = "Idempotency-Key"
+	//IdempotentReplayedHeader marks a response replayed from the idempotency store
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+
+	defaultIdempotencyTTL      = 24 * time.Hour
+	defaultIdempotencyEntries  = 10000
+	defaultIdempotencyBytes    = 64 << 20
+	idempotencyCleanupInterval = time.Minute
+)
+
+//ErrIdempotencyRecordTooLarge is returned by MemoryIdempotencyStore.Complete for a
+//response larger than MaxBytes, the response is not replayed
+var ErrIdempotencyRecordTooLarge = errors.New("idempotency record too large")
+
+//IdempotencyRecord is the state of an idempotency key, the response fields
+//are only set once the first request has completed
+type IdempotencyRecord struct {
+	RequestHash string
+	Completed   bool
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+//IdempotencyStore keeps the idempotency records, implement it to share them between processes
+type IdempotencyStore interface {
+	//Begin records an in-progress request for key unless the key is known,
+	//it returns nil when the caller should run the request, or the existing record
+	Begin(key string, requestHash string, ttl time.Duration) (*IdempotencyRecord, error)
+	//Complete stores the response of the request started by Begin
+	Complete(key string, record IdempotencyRecord, ttl time.Duration) error
+	//Release forgets a key whose request failed so that it can be retried
+	Release(key string) error
+}
+
+//IdempotencyConfig configures the Idempotency middleware
+type IdempotencyConfig struct {
+	//TTL is how long responses are replayed, 24 hours by default
+	TTL time.Duration
+	//Methods are the methods the key is honoured for, POST by default
+	Methods []string
+	//Required rejects requests without an Idempotency-Key with 400
+	Required bool
+	//Store defaults to a MemoryIdempotencyStore
+	Store IdempotencyStore
+	//MaxBodySize is the size of the bodies buffered to be hashed, larger requests
+	//are rejected with 413. It is 10MB by default.
+	MaxBodySize int64
+}
+
+//Idempotency is a middleware replaying the first response of a request carrying an
+//Idempotency-Key to its retries. A retry arriving while the first request is still
+//running gets 409, reusing a key with a different body gets 422. Only 2xx and client
+//error responses are stored, 408, 409 and 429 are transient and, like the 5xx ones,
+//are not stored so that the request can be retried.
+func Idempotency(c IdempotencyConfig) Middleware {
+	if c.TTL <= 0 {
+		c.TTL = defaultIdempotencyTTL
+	}
+	if len(c.Methods) == 0 {
+		c.Methods = []string{http.MethodPost}
+	}
+	if c.Store == nil {
+		c.Store = NewMemoryIdempotencyStore()
+	}
+	if c.MaxBodySize <= 0 {
+		c.MaxBodySize = defaultMaxSignedBody
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			if !containsMethod(c.Methods, r.Method) {
+				next.ServeHTTP(rw, r)
+				return
+			}
+			idempotencyKey := r.Header.Get(IdempotencyKeyHeader)
+			if len(idempotencyKey) == 0 {
+				if c.Required {
+					WriteError(rw, http.StatusBadRequest, IdempotencyKeyHeader+" header is required")
+					return
+				}
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			body, err := readBody(r, c.MaxBodySize)
+			if err == ErrBodyTooLarge {
+				WriteError(rw, ErrBodyTooLarge.Code, ErrBodyTooLarge.Message)
+				return
+			}
+			if err != nil {
+				WriteError(rw, http.StatusBadRequest, "failed to read request body")
+				return
+			}
+			sum := sha256.Sum256(append([]byte(r.Method+" "+r.URL.RequestURI()+"\n"), body...))
+			hash := hex.EncodeToString(sum[:])
+
+			//keys are scoped by caller and route so that clients can neither collide nor
+			//replay the responses of others, anonymous callers by their ip
+			key := r.Method + " " + r.URL.Path + "|" + idempotencyKey
+			if principal := GetPrincipal(r.Context()); principal != nil {
+				key = "principal:" + principal.Method + ":" + principal.ID + "|" + key
+			} else {
+				key = "ip:" + ClientIPKey(r) + "|" + key
+			}
+
+			existing, err := c.Store.Begin(key, hash, c.TTL)
+			if err != nil {
+				//fail open like the rate limiter, the store must not take the service down
+				logging.WithError("idempotency store error", err)
+				next.ServeHTTP(rw, r)
+				return
+			}
+			if existing != nil {
+				switch {
+				case existing.RequestHash != hash:
+					WriteError(rw, http.StatusUnprocessableEntity, IdempotencyKeyHeader+" was used with a different request")
+				case !existing.Completed:
+					WriteError(rw, http.StatusConflict, "a request with the same "+IdempotencyKeyHeader+" is in progress")
+				default:
+					rw.Header().Set(IdempotentReplayedHeader, "true")
+					(&bufferedResponse{
+						status: existing.Status,
+						header: existing.Header,
+						body:   existing.Body,
+					}).writeTo(rw)
+				}
+				return
+			}
+
+			completed := false
+			defer func() {
+				if !completed {
+					c.Store.Release(key)
+				}
+			}()
+
+			w := newBufferedWriter()
+			next.ServeHTTP(w, r)
+			rsp := w.response()
+			if storableStatus(rsp.status) {
+				err = c.Store.Complete(key, IdempotencyRecord{
+					RequestHash: hash,
+					Completed:   true,
+					Status:      rsp.status,
+					Header:      rsp.header,
+					Body:        rsp.body,
+				}, c.TTL)
+				if err != nil {
+					logging.WithError("idempotency store error", err)
+				} else {
+					completed = true
+				}
+			}
+			rsp.writeTo(rw)
+		})
+	}
+}
+
+//storableStatus returns true for the responses a retry would get again
+func storableStatus(status int) bool {
+	switch status {
+	case http.StatusRequestTimeout, http.StatusConflict, http.StatusTooManyRequests:
+		return false
+	}
+	return status >= http.StatusOK && status < http.StatusMultipleChoices ||
+		status >= http.StatusBadRequest && status < http.StatusInternalServerError
+}
+
+func containsMethod(methods []string, method string) bool {
+	for _, m := range methods {
+		if m == method {
+			return true
+		}
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// In-memory store
+// ---------------------------------------------------------------------------
+
+type idempotencyEntry struct {
+	key     string
+	record  IdempotencyRecord
+	expires time.Time
+	size    int64
+}
+
+//MemoryIdempotencyStore keeps idempotency records in the memory of the process,
+//the oldest completed records are evicted beyond MaxEntries or MaxBytes, the records
+//of requests in progress are kept so that their retries are still rejected
+type MemoryIdempotencyStore struct {
+	sync.Mutex
+	//MaxEntries bounds the number of records, 10000 by default
+	MaxEntries int
+	//MaxBytes bounds the size of the stored responses, 64MB by default
+	MaxBytes int64
+
+	entries     map[string]*list.Element
+	order       *list.List
+	bytes       int64
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+//NewMemoryIdempotencyStore returns an empty MemoryIdempotencyStore
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		MaxEntries:  defaultIdempotencyEntries,
+		MaxBytes:    defaultIdempotencyBytes,
+		entries:     make(map[string]*list.Element),
+		order:       list.New(),
+		lastCleanup: time.Now(),
+		now:         time.Now,
+	}
+}
+
+//Begin implement IdempotencyStore interface
+func (s *MemoryIdempotencyStore) Begin(key string, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	s.cleanup(now)
+	if el, ok := s.entries[key]; ok && now.Before(el.Value.(*idempotencyEntry).expires) {
+		record := el.Value.(*idempotencyEntry).record
+		return &record, nil
+	}
+	s.put(&idempotencyEntry{
+		key:     key,
+		record:  IdempotencyRecord{RequestHash: requestHash},
+		expires: now.Add(ttl),
+	})
+	return nil, nil
+}
+
+//Complete implement IdempotencyStore interface
+func (s *MemoryIdempotencyStore) Complete(key string, record IdempotencyRecord, ttl time.Duration) error {
+	size := int64(len(record.Body))
+	for name, values := range record.Header {
+		size += int64(len(name))
+		for _, value := range values {
+			size += int64(len(value))
+		}
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if size > s.MaxBytes {
+		return ErrIdempotencyRecordTooLarge
+	}
+	s.put(&idempotencyEntry{
+		key:     key,
+		record:  record,
+		expires: s.now().Add(ttl),
+		size:    size,
+	})
+	return nil
+}
+
+//Release implement IdempotencyStore interface
+func (s *MemoryIdempotencyStore) Release(key string) error {
+	s.Lock()
+	defer s.Unlock()
+	if el, ok := s.entries[key]; ok {
+		s.remove(el)
+	}
+	return nil
+}
+
+//put stores entry as the newest record and evicts the oldest completed ones beyond the bounds
+func (s *MemoryIdempotencyStore) put(entry *idempotencyEntry) {
+	if el, ok := s.entries[entry.key]; ok {
+		s.remove(el)
+	}
+	s.entries[entry.key] = s.order.PushBack(entry)
+	s.bytes += entry.size
+	for el := s.order.Front(); el != nil && (s.order.Len() > s.MaxEntries || s.bytes > s.MaxBytes); {
+		next := el.Next()
+		if el.Value.(*idempotencyEntry).record.Completed {
+			s.remove(el)
+		}
+		el = next
+	}
+}
+
+func (s *MemoryIdempotencyStore) remove(el *list.Element) {
+	entry := s.order.Remove(el).(*idempotencyEntry)
+	delete(s.entries, entry.key)
+	s.bytes -= entry.size
+}
+
+func (s *MemoryIdempotencyStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < idempotencyCleanupInterval {
+		return
+	}
+	s.lastCleanup = now
+	for _, el := range s.entries {
+		if !now.Before(el.Value.(*idempotencyEntry).expires) {
+			s.remove(el)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	var calls int32
+	block := make(chan struct{})
+	entered := make(chan struct{})
+	h := Idempotency(IdempotencyConfig{})(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-block
+		}
+		if r.URL.Path == "/fail" {
+			WriteError(rw, http.StatusBadGateway, "wechat unavailable")
+			return
+		}
+		if r.URL.Path == "/busy" {
+			WriteError(rw, http.StatusTooManyRequests, "slow down")
+			return
+		}
+		if r.URL.Path == "/invalid" {
+			WriteError(rw, http.StatusBadRequest, "invalid chat "+strconv.Itoa(int(n)))
+			return
+		}
+		rw.Header().Set("X-Message", "sent")
+		rw.WriteHeader(http.StatusCreated)
+		rw.Write([]byte("message " + strconv.Itoa(int(n))))
+	}))
+
+	do := func(path string, key string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if len(key) > 0 {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		key      string
+		body     string
+		status   int
+		response string
+		replayed bool
+		calls    int32
+	}{
+		{"first request", "/alerts", "k1", "a", 201, "message 1", false, 1},
+		{"retry replays", "/alerts", "k1", "a", 201, "message 1", true, 1},
+		{"different body", "/alerts", "k1", "b", 422, "", false, 1},
+		{"same key other route", "/other", "k1", "a", 201, "message 2", false, 2},
+		{"without key", "/alerts", "", "a", 201, "message 3", false, 3},
+		{"failure is not stored", "/fail", "k2", "a", 502, "", false, 4},
+		{"failure retried", "/fail", "k2", "a", 502, "", false, 5},
+		{"rate limited is not stored", "/busy", "k4", "a", 429, "", false, 6},
+		{"rate limited retried", "/busy", "k4", "a", 429, "", false, 7},
+		{"client error is stored", "/invalid", "k5", "a", 400, "", false, 8},
+		{"client error replays", "/invalid", "k5", "a", 400, `{"code":400,"message":"invalid chat 8"}` + "\n", true, 8},
+	}
+	for _, tt := range tests {
+		rw := do(tt.path, tt.key, tt.body)
+		if rw.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, rw.Code, tt.status)
+		}
+		if len(tt.response) > 0 && rw.Body.String() != tt.response {
+			t.Errorf("%s: body = %q, want %q", tt.name, rw.Body.String(), tt.response)
+		}
+		if tt.status == 201 && rw.Header().Get("X-Message") != "sent" {
+			t.Errorf("%s: headers = %v, want X-Message", tt.name, rw.Header())
+		}
+		if replayed := rw.Header().Get(IdempotentReplayedHeader) == "true"; replayed != tt.replayed {
+			t.Errorf("%s: replayed = %v, want %v", tt.name, replayed, tt.replayed)
+		}
+		if got := atomic.LoadInt32(&calls); got != tt.calls {
+			t.Errorf("%s: handler calls = %d, want %d", tt.name, got, tt.calls)
+		}
+	}
+
+	//anonymous callers can not replay the responses of others by guessing their keys
+	req := httptest.NewRequest(http.MethodPost, "/alerts", strings.NewReader("a"))
+	req.RemoteAddr = "198.51.100.7:4242"
+	req.Header.Set(IdempotencyKeyHeader, "k1")
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if rw.Code != http.StatusCreated || rw.Header().Get(IdempotentReplayedHeader) == "true" {
+		t.Errorf("other caller status = %d, headers %v, want a new response", rw.Code, rw.Header())
+	}
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		done <- do("/slow", "k3", "a")
+	}()
+	<-entered
+	if rw := do("/slow", "k3", "a"); rw.Code != http.StatusConflict {
+		t.Errorf("concurrent duplicate status = %d, want 409", rw.Code)
+	}
+	close(block)
+	if rw := <-done; rw.Code != http.StatusCreated {
+		t.Errorf("slow request status = %d, want 201", rw.Code)
+	}
+}
+
+func TestMemoryIdempotencyStore_Expiry(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	store := NewMemoryIdempotencyStore()
+	store.now = func() time.Time { return now }
+
+	if existing, _ := store.Begin("k", "h", time.Minute); existing != nil {
+		t.Fatalf("Begin() = %v, want nil for a new key", existing)
+	}
+	store.Complete("k", IdempotencyRecord{RequestHash: "h", Completed: true, Status: 200}, time.Minute)
+	if existing, _ := store.Begin("k", "h", time.Minute); existing == nil || !existing.Completed {
+		t.Fatalf("Begin() = %v, want the completed record", existing)
+	}
+
+	now = now.Add(time.Minute)
+	if existing, _ := store.Begin("k", "h", time.Minute); existing != nil {
+		t.Errorf("Begin() after ttl = %v, want nil", existing)
+	}
+}
+
+func TestMemoryIdempotencyStore_Bounds(t *testing.T) {
+	store := NewMemoryIdempotencyStore()
+	store.MaxEntries = 2
+	store.MaxBytes = 10
+
+	store.Begin("a", "h", time.Minute)
+	store.Complete("a", IdempotencyRecord{Completed: true}, time.Minute)
+	for _, key := range []string{"b", "c"} {
+		store.Begin(key, "h", time.Minute)
+	}
+	if _, ok := store.entries["a"]; ok || len(store.entries) != 2 {
+		t.Errorf("entries = %v, want the oldest evicted", store.entries)
+	}
+	store.Begin("d", "h", time.Minute)
+	if len(store.entries) != 3 {
+		t.Errorf("entries = %v, want the requests in progress kept", store.entries)
+	}
+
+	store.Complete("b", IdempotencyRecord{Completed: true, Body: []byte("123456")}, time.Minute)
+	store.Complete("c", IdempotencyRecord{Completed: true, Body: []byte("123456")}, time.Minute)
+	if _, ok := store.entries["b"]; ok || store.bytes != 6 {
+		t.Errorf("entries = %v, bytes %d, want b evicted by MaxBytes", store.entries, store.bytes)
+	}
+	if _, ok := store.entries["d"]; !ok {
+		t.Errorf("entries = %v, want d in progress kept", store.entries)
+	}
+	if err := store.Complete("d", IdempotencyRecord{Body: make([]byte, 11)}, time.Minute); err != ErrIdempotencyRecordTooLarge {
+		t.Errorf("Complete() of a large record error = %v", err)
+	}
+}
@@ -0,0 +1,306 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+//JSON-RPC 2.0 error codes
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	//RPCServerError is the code of the errors returned by methods that are not *RPCError
+	RPCServerError = -32000
+)
+
+const (
+	maxRPCBatch = 100
+	maxRPCBody  = 4 << 20
+)
+
+var (
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+//RPCError is the JSON-RPC error object, methods return it to choose the code and data
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+//RPCServer dispatches JSON-RPC 2.0 requests, including batches and notifications,
+//to the registered methods. It is an ApiHandler, so it runs behind the middlewares,
+//timeouts and access log of the route it is registered on.
+type RPCServer struct {
+	mu      sync.RWMutex
+	methods map[string]*rpcMethod
+}
+
+type rpcMethod struct {
+	fn     reflect.Value
+	params reflect.Type
+	result bool
+}
+
+//NewRPCServer returns a dispatcher without methods
+func NewRPCServer() *RPCServer {
+	return &RPCServer{methods: make(map[string]*rpcMethod)}
+}
+
+//RegisterRPC serves rpc with POST requests to url, other methods get 405
+func (s *HTTPServer) RegisterRPC(url string, rpc *RPCServer, opts ...RouteOption) {
+	s.RegisterApiHandler(url, rpc, append([]RouteOption{WithMethods(http.MethodPost)}, opts...)...)
+}
+
+//Register registers fn as method name, fn is one of
+//
+//	func(ctx context.Context) (R, error)
+//	func(ctx context.Context, params P) (R, error)
+//	func(ctx context.Context) error
+//	func(ctx context.Context, params P) error
+//
+//Params given by name are decoded into P, params given by position are decoded into
+//the elements of P if it is a slice and into the exported fields of P in order if it is a struct.
+func (s *RPCServer) Register(name string, fn interface{}) error {
+	v := reflect.ValueOf(fn)
+	t := v.Type()
+	if t.Kind() != reflect.Func || t.NumIn() < 1 || t.NumIn() > 2 || t.In(0) != contextType ||
+		t.NumOut() < 1 || t.NumOut() > 2 || t.Out(t.NumOut()-1) != errorType {
+		return fmt.Errorf("method %s must be func(context.Context[, P]) ([R, ]error), not %s", name, t)
+	}
+
+	method := &rpcMethod{fn: v, result: t.NumOut() == 2}
+	if t.NumIn() == 2 {
+		method.params = t.In(1)
+	}
+	s.mu.Lock()
+	s.methods[name] = method
+	s.mu.Unlock()
+	return nil
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+	//ID is empty for notifications and null for requests with a null id
+	ID json.RawMessage `json:"id"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+//Serve dispatches the single or batch request of req, only POST requests are accepted
+func (s *RPCServer) Serve(ctx context.Context, req *http.Request) (interface{}, int) {
+	if req.Method != http.MethodPost {
+		SetHeader(ctx, "Allow", http.MethodPost)
+		return &ErrorResponse{Code: http.StatusMethodNotAllowed, Message: "method not allowed"}, 0
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(req.Body, maxRPCBody+1))
+	if err != nil || len(body) > maxRPCBody {
+		return rpcRaw(rpcErrorResponse(nil, &RPCError{Code: RPCInvalidRequest, Message: "request body is too large"}))
+	}
+	body = bytes.TrimSpace(body)
+	if !json.Valid(body) {
+		return rpcRaw(rpcErrorResponse(nil, &RPCError{Code: RPCParseError, Message: "parse error"}))
+	}
+
+	if body[0] != '[' {
+		var rpcReq rpcRequest
+		if err := json.Unmarshal(body, &rpcReq); err != nil {
+			return rpcRaw(rpcErrorResponse(nil, &RPCError{Code: RPCInvalidRequest, Message: "invalid request"}))
+		}
+		return rpcRaw(s.call(ctx, &rpcReq))
+	}
+
+	var batch []json.RawMessage
+	if err := json.Unmarshal(body, &batch); err != nil {
+		return rpcRaw(rpcErrorResponse(nil, &RPCError{Code: RPCParseError, Message: "parse error"}))
+	}
+	if len(batch) == 0 || len(batch) > maxRPCBatch {
+		return rpcRaw(rpcErrorResponse(nil, &RPCError{Code: RPCInvalidRequest,
+			Message: fmt.Sprintf("batch must have 1 to %d requests", maxRPCBatch)}))
+	}
+	responses := make([]*rpcResponse, 0, len(batch))
+	for _, item := range batch {
+		var rpcReq rpcRequest
+		if err := json.Unmarshal(item, &rpcReq); err != nil {
+			responses = append(responses, rpcErrorResponse(nil, &RPCError{Code: RPCInvalidRequest, Message: "invalid request"}))
+			continue
+		}
+		if rsp := s.call(ctx, &rpcReq); rsp != nil {
+			responses = append(responses, rsp)
+		}
+	}
+	if len(responses) == 0 {
+		return rpcRaw(nil)
+	}
+	return rpcRaw(responses)
+}
+
+//call runs a request, it returns nil for notifications
+func (s *RPCServer) call(ctx context.Context, req *rpcRequest) *rpcResponse {
+	notification := len(req.ID) == 0
+	if req.JSONRPC != "2.0" || len(req.Method) == 0 || !validRPCID(req.ID) {
+		return rpcErrorResponse(req.ID, &RPCError{Code: RPCInvalidRequest, Message: "invalid request"})
+	}
+
+	result, rpcErr := s.invoke(ctx, req)
+	if notification {
+		return nil
+	}
+	if rpcErr != nil {
+		return rpcErrorResponse(req.ID, rpcErr)
+	}
+	return &rpcResponse{JSONRPC: "2.0", Result: result, ID: req.ID}
+}
+
+func (s *RPCServer) invoke(ctx context.Context, req *rpcRequest) (result json.RawMessage, rpcErr *RPCError) {
+	s.mu.RLock()
+	method, ok := s.methods[req.Method]
+	s.mu.RUnlock()
+	if !ok {
+		return nil, &RPCError{Code: RPCMethodNotFound, Message: "method not found: " + req.Method}
+	}
+
+	args := []reflect.Value{reflect.ValueOf(ctx)}
+	if method.params != nil {
+		params, err := decodeRPCParams(req.Params, method.params)
+		if err != nil {
+			return nil, &RPCError{Code: RPCInvalidParams, Message: "invalid params: " + err.Error()}
+		}
+		args = append(args, params)
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			logging.Error("rpc method panicked", logging.Fields{
+				"method": req.Method,
+			}, fmt.Errorf("%v", v))
+			result, rpcErr = nil, &RPCError{Code: RPCInternalError, Message: "internal error"}
+		}
+	}()
+	out := method.fn.Call(args)
+	if err, _ := out[len(out)-1].Interface().(error); err != nil {
+		return nil, toRPCError(req.Method, err)
+	}
+	if !method.result {
+		return json.RawMessage("null"), nil
+	}
+	data, err := json.Marshal(out[0].Interface())
+	if err != nil {
+		return nil, &RPCError{Code: RPCInternalError, Message: "encode result failed"}
+	}
+	return data, nil
+}
+
+//decodeRPCParams decodes params given by name or by position into a new value of t
+func decodeRPCParams(params json.RawMessage, t reflect.Type) (reflect.Value, error) {
+	ptr := reflect.New(t)
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return ptr.Elem(), nil
+	}
+
+	target := ptr.Elem()
+	if target.Kind() == reflect.Ptr {
+		target.Set(reflect.New(t.Elem()))
+		target = target.Elem()
+	}
+	if params[0] == '[' && target.Kind() == reflect.Struct {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(params, &positional); err != nil {
+			return reflect.Value{}, err
+		}
+		var fields []reflect.Value
+		for i := 0; i < target.NumField(); i++ {
+			if len(target.Type().Field(i).PkgPath) == 0 {
+				fields = append(fields, target.Field(i))
+			}
+		}
+		if len(positional) > len(fields) {
+			return reflect.Value{}, fmt.Errorf("expected at most %d params, got %d", len(fields), len(positional))
+		}
+		for i, param := range positional {
+			if err := json.Unmarshal(param, fields[i].Addr().Interface()); err != nil {
+				return reflect.Value{}, err
+			}
+		}
+		return ptr.Elem(), nil
+	}
+	if err := json.Unmarshal(params, ptr.Interface()); err != nil {
+		return reflect.Value{}, err
+	}
+	return ptr.Elem(), nil
+}
+
+//toRPCError keeps the *RPCError of err and logs and hides the message of unexpected errors
+func toRPCError(method string, err error) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return &RPCError{Code: RPCServerError, Message: statusErr.Error(), Data: map[string]int{"status": statusErr.StatusCode()}}
+	}
+	logging.Error("rpc method failed", logging.Fields{
+		"method": method,
+	}, err)
+	return &RPCError{Code: RPCServerError, Message: "server error"}
+}
+
+//validRPCID accepts the string, number and null ids of the spec
+func validRPCID(id json.RawMessage) bool {
+	if len(id) == 0 {
+		return true
+	}
+	var v interface{}
+	if json.Unmarshal(id, &v) != nil {
+		return false
+	}
+	switch v.(type) {
+	case nil, string, float64:
+		return true
+	}
+	return false
+}
+
+func rpcErrorResponse(id json.RawMessage, err *RPCError) *rpcResponse {
+	if !validRPCID(id) {
+		id = nil
+	}
+	return &rpcResponse{JSONRPC: "2.0", Error: err, ID: id}
+}
+
+//rpcRaw writes v as the JSON body, nil writes an empty body for notifications
+func rpcRaw(v interface{}) (interface{}, int) {
+	if v == nil || reflect.ValueOf(v).IsNil() {
+		return &RawResponse{}, 0
+	}
+	data, _ := json.Marshal(v)
+	return &RawResponse{ContentType: "application/json", Body: data}, 0
+}
@@ -0,0 +1,186 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type sumParams struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func newTestRPCServer(t *testing.T, notified chan<- string) *RPCServer {
+	rpc := NewRPCServer()
+	methods := map[string]interface{}{
+		"sum": func(ctx context.Context, p sumParams) (int, error) {
+			return p.A + p.B, nil
+		},
+		"whoami": func(ctx context.Context) (string, error) {
+			return GetPrincipal(ctx).ID, nil
+		},
+		"notify": func(ctx context.Context, message []string) error {
+			notified <- strings.Join(message, " ")
+			return nil
+		},
+		"denied": func(ctx context.Context) (string, error) {
+			return "", &RPCError{Code: 403, Message: "denied", Data: "ops only"}
+		},
+		"broken": func(ctx context.Context) (string, error) {
+			return "", errors.New("connection refused by db-1")
+		},
+		"panics": func(ctx context.Context) (string, error) {
+			panic("boom")
+		},
+	}
+	for name, fn := range methods {
+		if err := rpc.Register(name, fn); err != nil {
+			t.Fatalf("Register(%s) error = %v", name, err)
+		}
+	}
+	return rpc
+}
+
+func TestRPCServer(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{
+			name: "params by name",
+			body: `{"jsonrpc":"2.0","method":"sum","params":{"a":1,"b":2},"id":1}`,
+			want: `{"jsonrpc":"2.0","result":3,"id":1}`,
+		},
+		{
+			name: "params by position",
+			body: `{"jsonrpc":"2.0","method":"sum","params":[3,4],"id":"a"}`,
+			want: `{"jsonrpc":"2.0","result":7,"id":"a"}`,
+		},
+		{
+			name: "method reads the request context",
+			body: `{"jsonrpc":"2.0","method":"whoami","id":2}`,
+			want: `{"jsonrpc":"2.0","result":"alice","id":2}`,
+		},
+		{
+			name: "notification",
+			body: `{"jsonrpc":"2.0","method":"notify","params":["disk","full"]}`,
+			want: ``,
+		},
+		{
+			name: "batch",
+			body: `[{"jsonrpc":"2.0","method":"sum","params":[1,1],"id":1},` +
+				`{"jsonrpc":"2.0","method":"notify","params":["batch"]},` +
+				`{"jsonrpc":"2.0","method":"missing","id":2},` +
+				`1]`,
+			want: `[{"jsonrpc":"2.0","result":2,"id":1},` +
+				`{"jsonrpc":"2.0","error":{"code":-32601,"message":"method not found: missing"},"id":2},` +
+				`{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":null}]`,
+		},
+		{
+			name: "rpc error",
+			body: `{"jsonrpc":"2.0","method":"denied","id":3}`,
+			want: `{"jsonrpc":"2.0","error":{"code":403,"message":"denied","data":"ops only"},"id":3}`,
+		},
+		{
+			name: "go error is hidden",
+			body: `{"jsonrpc":"2.0","method":"broken","id":4}`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32000,"message":"server error"},"id":4}`,
+		},
+		{
+			name: "panic",
+			body: `{"jsonrpc":"2.0","method":"panics","id":5}`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32603,"message":"internal error"},"id":5}`,
+		},
+		{
+			name: "invalid params",
+			body: `{"jsonrpc":"2.0","method":"sum","params":{"a":"one"},"id":6}`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32602,"message":"invalid params: ` +
+				`json: cannot unmarshal string into Go struct field sumParams.a of type int"},"id":6}`,
+		},
+		{
+			name: "invalid version",
+			body: `{"jsonrpc":"1.0","method":"sum","id":7}`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"invalid request"},"id":7}`,
+		},
+		{
+			name: "parse error",
+			body: `{"jsonrpc":"2.0",`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32700,"message":"parse error"},"id":null}`,
+		},
+		{
+			name: "empty batch",
+			body: `[]`,
+			want: `{"jsonrpc":"2.0","error":{"code":-32600,"message":"batch must have 1 to 100 requests"},"id":null}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notified := make(chan string, 1)
+			s := NewHTTPServer("localhost", 0, "/api", WithoutAccessLog())
+			s.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+					ctx := context.WithValue(r.Context(), PrincipalKey, &Principal{ID: "alice"})
+					next.ServeHTTP(rw, r.WithContext(ctx))
+				})
+			})
+			s.RegisterRPC("/rpc", newTestRPCServer(t, notified))
+
+			rw := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/api/rpc", strings.NewReader(tt.body)))
+			if rw.Code != http.StatusOK {
+				t.Fatalf("status = %d, want 200", rw.Code)
+			}
+			if got := strings.TrimSpace(rw.Body.String()); got != tt.want {
+				t.Errorf("body = %s\nwant %s", got, tt.want)
+			}
+			if len(tt.want) > 0 && rw.Header().Get("Content-Type") != "application/json" {
+				t.Errorf("Content-Type = %s, want application/json", rw.Header().Get("Content-Type"))
+			}
+		})
+	}
+}
+
+func TestRPCServer_Method(t *testing.T) {
+	s := NewHTTPServer("localhost", 0, "/api", WithoutAccessLog())
+	s.RegisterRPC("/rpc", newTestRPCServer(t, make(chan string, 1)))
+
+	rw := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/rpc", nil))
+	if rw.Code != http.StatusMethodNotAllowed || rw.Header().Get("Allow") != http.MethodPost {
+		t.Errorf("GET status = %d, Allow %q, want 405 allowing POST", rw.Code, rw.Header().Get("Allow"))
+	}
+}
+
+func TestRPCServer_Register(t *testing.T) {
+	rpc := NewRPCServer()
+	invalid := []interface{}{
+		"not a func",
+		func() error { return nil },
+		func(ctx context.Context) int { return 0 },
+		func(ctx context.Context, a int, b int) error { return nil },
+	}
+	for _, fn := range invalid {
+		if err := rpc.Register("invalid", fn); err == nil {
+			t.Errorf("Register(%T) error = nil, want invalid signature", fn)
+		}
+	}
+
+	if err := rpc.Register("ping", func(ctx context.Context) error { return nil }); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	rsp, _ := rpc.Serve(context.Background(), httptest.NewRequest(http.MethodPost, "/rpc",
+		strings.NewReader(`{"jsonrpc":"2.0","method":"ping","id":null}`)))
+	var got map[string]interface{}
+	if err := json.Unmarshal(rsp.(*RawResponse).Body, &got); err != nil {
+		t.Fatalf("decode error = %v", err)
+	}
+	if result, ok := got["result"]; !ok || result != nil || got["id"] != nil {
+		t.Errorf("response = %v, want a null result for the null id", got)
+	}
+}
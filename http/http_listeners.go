@@ -0,0 +1,123 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+//ListenerConfig is an additional listener serving the routes of the server next to
+//host:port, e.g. an internal port or a unix domain socket for sidecars. Additional
+//listeners never use TLS.
+type ListenerConfig struct {
+	//Network is tcp, the default, or unix
+	Network string
+	//Addr is host:port for tcp and the socket path for unix
+	Addr string
+	//Listener serves on an existing listener instead of Network and Addr
+	Listener net.Listener
+	//H2C serves HTTP/2 without TLS next to HTTP/1.1
+	H2C bool
+}
+
+//WithListeners serves the routes on additional listeners, they start and shut down
+//together with the main listener
+func WithListeners(listeners ...ListenerConfig) ServerOption {
+	return func(s *HTTPServer) {
+		s.extraListeners = append(s.extraListeners, listeners...)
+	}
+}
+
+//WithH2C serves HTTP/2 without TLS next to HTTP/1.1 on the main listener, it is
+//ignored with TLS since the clients negotiate HTTP/2 over TLS
+func WithH2C() ServerOption {
+	return func(s *HTTPServer) {
+		s.h2c = true
+	}
+}
+
+//Addrs returns the addresses of the main and additional listeners, empty before Start
+func (s *HTTPServer) Addrs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]string, 0, len(s.listeners))
+	for _, l := range s.listeners {
+		addrs = append(addrs, l.Addr().String())
+	}
+	return addrs
+}
+
+func (c ListenerConfig) listen() (net.Listener, error) {
+	if c.Listener != nil {
+		return c.Listener, nil
+	}
+	if c.Network == "unix" {
+		return listenUnix(c.Addr)
+	}
+	return net.Listen("tcp", c.Addr)
+}
+
+//newServer returns the server of a listener, all of them share the handler and timeouts
+func (s *HTTPServer) newServer(handler http.Handler, cleartextHTTP2 bool, serving context.Context) *http.Server {
+	server := &http.Server{
+		Handler:           handler,
+		ReadTimeout:       s.readTimeout,
+		ReadHeaderTimeout: s.readHeaderTimeout,
+		WriteTimeout:      s.writeTimeout,
+		IdleTimeout:       s.idleTimeout,
+		BaseContext: func(net.Listener) context.Context {
+			return serving
+		},
+	}
+	if cleartextHTTP2 {
+		h2s := &http2.Server{IdleTimeout: s.idleTimeout}
+		//sends GOAWAY to the HTTP/2 connections on Shutdown
+		http2.ConfigureServer(server, h2s)
+		server.Handler = h2c.NewHandler(s.trackH2C(handler), h2s)
+	}
+	return server
+}
+
+//trackH2C counts the HTTP/2 requests so that Shutdown drains them, the streams still
+//opened on the connections after Shutdown started get 503
+func (s *HTTPServer) trackH2C(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor == 2 {
+			s.mu.Lock()
+			if s.h2cClosed {
+				s.mu.Unlock()
+				WriteError(rw, http.StatusServiceUnavailable, "server is shutting down")
+				return
+			}
+			s.h2cGroup.Add(1)
+			s.mu.Unlock()
+			defer s.h2cGroup.Done()
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func closeListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+//waitGroup waits for wg until ctx is done
+func waitGroup(ctx context.Context, wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
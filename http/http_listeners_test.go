@@ -0,0 +1,138 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+func TestHTTPServer_Listeners(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "orb.sock")
+
+	s := NewHTTPServer("127.0.0.1", 0, "", WithoutAccessLog(), WithH2C(), WithDrainTimeout(time.Second),
+		WithListeners(
+			ListenerConfig{Addr: "127.0.0.1:0"},
+			ListenerConfig{Network: "unix", Addr: socket, H2C: true},
+		))
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	s.RegisterApiHandler("/proto", ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		if req.URL.Query().Get("wait") == "true" {
+			started <- struct{}{}
+			<-release
+		}
+		return req.Proto, 0
+	}))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	addrs := s.Addrs()
+	if len(addrs) != 3 || addrs[0] != s.Addr() || addrs[2] != socket {
+		t.Fatalf("Addrs() = %v, want the main, tcp and unix listeners", addrs)
+	}
+
+	h1 := &http.Client{}
+	h2c := func(network string, addr string) *http.Client {
+		return &http.Client{Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(string, string, *tls.Config) (net.Conn, error) {
+				return net.Dial(network, addr)
+			},
+		}}
+	}
+	tests := []struct {
+		name   string
+		client *http.Client
+		url    string
+		proto  string
+	}{
+		{"main listener HTTP/1.1", h1, "http://" + addrs[0] + "/proto", "HTTP/1.1"},
+		{"main listener h2c", h2c("tcp", addrs[0]), "http://" + addrs[0] + "/proto", "HTTP/2.0"},
+		{"additional tcp listener", h1, "http://" + addrs[1] + "/proto", "HTTP/1.1"},
+		{"unix socket h2c", h2c("unix", socket), "http://unix/proto", "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := tt.client.Get(tt.url)
+			if err != nil {
+				t.Fatalf("GET %s error = %v", tt.url, err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if string(body) != `"`+tt.proto+`"`+"\n" {
+				t.Errorf("GET %s body = %s, want %s", tt.url, body, tt.proto)
+			}
+		})
+	}
+
+	//an in-flight HTTP/2 cleartext request is drained by Shutdown
+	result := make(chan int, 1)
+	go func() {
+		res, err := h2c("unix", socket).Get("http://unix/proto?wait=true")
+		if err != nil {
+			t.Errorf("GET in-flight error = %v", err)
+			result <- 0
+			return
+		}
+		res.Body.Close()
+		result <- res.StatusCode
+	}()
+	<-started
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("Shutdown() = %v before the in-flight request completed", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+	if status := <-result; status != http.StatusOK {
+		t.Errorf("in-flight request status = %d, want 200", status)
+	}
+	for _, addr := range addrs[:2] {
+		if conn, err := net.Dial("tcp", addr); err == nil {
+			conn.Close()
+			t.Errorf("listener %s still accepts connections after Shutdown", addr)
+		}
+	}
+}
+
+func TestHTTPServer_TrackH2C(t *testing.T) {
+	s := NewHTTPServer("127.0.0.1", 0, "")
+	handler := s.trackH2C(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	do := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/proto", nil)
+		req.ProtoMajor = 2
+		rw := httptest.NewRecorder()
+		handler.ServeHTTP(rw, req)
+		return rw.Code
+	}
+
+	if status := do(); status != http.StatusOK {
+		t.Errorf("status = %d, want 200", status)
+	}
+	s.Shutdown(context.Background())
+	if status := do(); status != http.StatusServiceUnavailable {
+		t.Errorf("status after Shutdown = %d, want 503", status)
+	}
+}
@@ -0,0 +1,137 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"net"
+	"net/http"
+)
+
+//Middleware wraps a http.Handler with additional behaviour
+type Middleware func(http.Handler) http.Handler
+
+func chain(handler http.Handler, middlewares ...Middleware) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+//responseRecorder records the status code and body size written by the next handler
+type responseRecorder struct {
+	http.ResponseWriter
+	status      int
+	size        int
+	wroteHeader bool
+}
+
+func newResponseRecorder(rw http.ResponseWriter) *responseRecorder {
+	return &responseRecorder{
+		ResponseWriter: rw,
+		status:         http.StatusOK,
+	}
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	if r.wroteHeader {
+		return
+	}
+	r.status = code
+	r.wroteHeader = true
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.wroteHeader {
+		r.WriteHeader(http.StatusOK)
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.size += n
+	return n, err
+}
+
+//Flush implement http.Flusher interface if the underlying writer supports it
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+//Hijack implement http.Hijacker interface if the underlying writer supports it
+func (r *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not implement http.Hijacker")
+	}
+	r.status = http.StatusSwitchingProtocols
+	r.wroteHeader = true
+	return h.Hijack()
+}
+
+//Unwrap returns the underlying writer, used by http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+//bufferedWriter keeps the whole response in memory so that it can be inspected,
+//stored or replayed before it is written to the client
+type bufferedWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferedWriter() *bufferedWriter {
+	return &bufferedWriter{
+		header: make(http.Header),
+	}
+}
+
+func (w *bufferedWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+//bufferedResponse is a complete response recorded by bufferedWriter
+type bufferedResponse struct {
+	status int
+	header http.Header
+	body   []byte
+	//streamed is set when the handler flushed the response to the client itself
+	streamed bool
+}
+
+func (w *bufferedWriter) response() *bufferedResponse {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	return &bufferedResponse{
+		status: status,
+		header: w.header,
+		body:   w.body.Bytes(),
+	}
+}
+
+//writeTo copies the recorded headers, status and body to rw
+func (rsp *bufferedResponse) writeTo(rw http.ResponseWriter) {
+	header := rw.Header()
+	for key, values := range rsp.header {
+		header[key] = append([]string(nil), values...)
+	}
+	rw.WriteHeader(rsp.status)
+	rw.Write(rsp.body)
+}
@@ -0,0 +1,371 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//OpenAPIInfo is the info object of the generated OpenAPI document
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+//OpenAPI returns the OpenAPI 3 document of the routes registered so far,
+//request and response schemas are reflected from the documented Go types
+func (s *HTTPServer) OpenAPI(info OpenAPIInfo) map[string]interface{} {
+	s.mu.Lock()
+	routes := append([]*route{}, s.routes...)
+	s.mu.Unlock()
+
+	gen := &schemaGenerator{
+		schemas:      make(map[string]interface{}),
+		names:        make(map[reflect.Type]string),
+		operationIDs: make(map[string]bool),
+	}
+	paths := make(map[string]interface{})
+	for _, r := range routes {
+		if r.kind == "static" || r.kind == "proxy" {
+			continue
+		}
+		path, methods := openAPIPath(r)
+		item, ok := paths[path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[path] = item
+		}
+		for _, method := range methods {
+			item[strings.ToLower(method)] = gen.operation(r, method, path)
+		}
+	}
+
+	doc := map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    info,
+		"paths":   paths,
+	}
+	if len(gen.schemas) > 0 {
+		doc["components"] = map[string]interface{}{
+			"schemas": gen.schemas,
+		}
+	}
+	return doc
+}
+
+//ServeOpenAPI serves the OpenAPI document at specPath and a minimal viewer of it at docsPath,
+//an empty docsPath disables the viewer
+func (s *HTTPServer) ServeOpenAPI(specPath string, docsPath string, info OpenAPIInfo) {
+	s.mux.HandleFunc(specPath, func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(rw)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(s.OpenAPI(info))
+	})
+	if len(docsPath) == 0 {
+		return
+	}
+	s.mux.HandleFunc(docsPath, func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("Content-Type", "text/html; charset=utf-8")
+		rw.Write([]byte(strings.Replace(openAPIViewer, "{{spec}}", strconv.Quote(specPath), 1)))
+	})
+}
+
+//openAPIPath returns the OpenAPI path and methods of a route, patterns like
+//"POST /alerts/{id}" declare their method, subtree patterns end with a slash
+func openAPIPath(r *route) (string, []string) {
+	path, methods := r.pattern, r.methods
+	if i := strings.Index(path, " "); i > 0 {
+		methods = []string{path[:i]}
+		path = strings.TrimSpace(path[i+1:])
+	}
+	path = strings.Replace(path, "...}", "}", -1)
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet}
+	}
+	return path, methods
+}
+
+type schemaGenerator struct {
+	schemas      map[string]interface{}
+	names        map[reflect.Type]string
+	operationIDs map[string]bool
+}
+
+func (g *schemaGenerator) operation(r *route, method string, path string) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId": g.operationID(method, path),
+	}
+	if len(r.summary) > 0 {
+		op["summary"] = r.summary
+	}
+	if len(r.description) > 0 {
+		op["description"] = r.description
+	}
+	if len(r.tags) > 0 {
+		op["tags"] = r.tags
+	}
+	if r.deprecation != nil {
+		op["deprecated"] = true
+	}
+
+	var params []interface{}
+	for _, segment := range strings.Split(path, "/") {
+		if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+			params = append(params, map[string]interface{}{
+				"name":     strings.Trim(segment, "{}"),
+				"in":       "path",
+				"required": true,
+				"schema":   map[string]interface{}{"type": "string"},
+			})
+		}
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if r.request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"required": true,
+			"content": map[string]interface{}{
+				"application/json": map[string]interface{}{"schema": g.schema(r.request)},
+			},
+		}
+	}
+
+	responses := make(map[string]interface{})
+	for status, t := range r.responses {
+		response := map[string]interface{}{
+			"description": http.StatusText(status),
+		}
+		if t != nil {
+			response["content"] = map[string]interface{}{
+				contentType(r.kind): map[string]interface{}{"schema": g.schema(t)},
+			}
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+	if len(responses) == 0 {
+		responses["default"] = map[string]interface{}{"description": "response of " + r.kind + " handler"}
+	}
+	op["responses"] = responses
+
+	return op
+}
+
+//operationID returns the unique id of an operation derived from its method and path,
+//e.g. get_api_alerts_id, colliding ids get a numeric suffix
+func (g *schemaGenerator) operationID(method string, path string) string {
+	id := strings.ToLower(method) + "_" + strings.Trim(operationIDReplacer.Replace(path), "_")
+	id = strings.TrimSuffix(id, "_")
+	unique := id
+	for i := 2; g.operationIDs[unique]; i++ {
+		unique = id + "_" + strconv.Itoa(i)
+	}
+	g.operationIDs[unique] = true
+	return unique
+}
+
+var operationIDReplacer = strings.NewReplacer("/", "_", "{", "", "}", "", "-", "_", ".", "_")
+
+func contentType(kind string) string {
+	if kind == "stream" {
+		return "application/x-ndjson"
+	}
+	return "application/json"
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+//schema returns the JSON schema of t, named structs are added to the components
+func (g *schemaGenerator) schema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == rawMessageType:
+		return map[string]interface{}{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8,
+		reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer", "format": "int32"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if len(t.Name()) == 0 {
+			return g.structSchema(t)
+		}
+		name, ok := g.names[t]
+		if !ok {
+			name = g.uniqueName(t)
+			g.names[t] = name
+			//reserve the name before recursing so that recursive types terminate
+			g.schemas[name] = nil
+			g.schemas[name] = g.structSchema(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+//uniqueName returns the name of t qualified by its package when another type has the
+//name, and numbered when the packages also share their last path segment
+func (g *schemaGenerator) uniqueName(t reflect.Type) string {
+	name := t.Name()
+	if _, taken := g.schemas[name]; !taken {
+		return name
+	}
+	pkg := t.PkgPath()
+	if i := strings.LastIndex(pkg, "/"); i >= 0 {
+		pkg = pkg[i+1:]
+	}
+	name = pkg + "." + name
+	unique := name
+	for i := 2; ; i++ {
+		if _, taken := g.schemas[unique]; !taken {
+			return unique
+		}
+		unique = name + strconv.Itoa(i)
+	}
+}
+
+func (g *schemaGenerator) structSchema(t reflect.Type) map[string]interface{} {
+	properties := make(map[string]interface{})
+	var required []string
+	g.fields(t, properties, &required)
+
+	schema := map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		schema["required"] = required
+	}
+	return schema
+}
+
+//fields collects the JSON properties of t following the encoding/json rules
+func (g *schemaGenerator) fields(t reflect.Type, properties map[string]interface{}, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if i := strings.Index(tag, ","); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+
+		ft := field.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if field.Anonymous && len(name) == 0 && ft.Kind() == reflect.Struct {
+			g.fields(ft, properties, required)
+			continue
+		}
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+
+		schema := g.schema(field.Type)
+		if doc := field.Tag.Get("doc"); len(doc) > 0 {
+			if _, isRef := schema["$ref"]; isRef {
+				schema = map[string]interface{}{"allOf": []interface{}{schema}}
+			}
+			schema["description"] = doc
+		}
+		properties[name] = schema
+		if !strings.Contains(opts, "omitempty") && field.Type.Kind() != reflect.Ptr {
+			*required = append(*required, name)
+		}
+	}
+}
+
+const openAPIViewer = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>API documentation</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.op { border: 1px solid #ddd; border-radius: 4px; margin: .5em 0; }
+.op summary { padding: .5em; cursor: pointer; }
+.method { display: inline-block; width: 5em; font-weight: bold; text-transform: uppercase; }
+pre { background: #f6f8fa; padding: 1em; margin: 0; overflow: auto; }
+</style>
+</head>
+<body>
+<h1 id="title"></h1>
+<p id="description"></p>
+<div id="paths"></div>
+<script>
+fetch({{spec}}).then(function (res) { return res.json(); }).then(function (doc) {
+  document.getElementById("title").textContent = doc.info.title + " " + doc.info.version;
+  document.getElementById("description").textContent = doc.info.description || "";
+  var container = document.getElementById("paths");
+  Object.keys(doc.paths).sort().forEach(function (path) {
+    Object.keys(doc.paths[path]).forEach(function (method) {
+      var op = doc.paths[path][method];
+      var details = document.createElement("details");
+      details.className = "op";
+      var summary = document.createElement("summary");
+      var m = document.createElement("span");
+      m.className = "method";
+      m.textContent = method;
+      summary.appendChild(m);
+      summary.appendChild(document.createTextNode(path + "  " + (op.summary || "")));
+      details.appendChild(summary);
+      var body = document.createElement("pre");
+      body.textContent = JSON.stringify(op, null, 2);
+      details.appendChild(body);
+      container.appendChild(details);
+    });
+  });
+  if (doc.components) {
+    var schemas = document.createElement("details");
+    schemas.className = "op";
+    schemas.innerHTML = "<summary>schemas</summary>";
+    var pre = document.createElement("pre");
+    pre.textContent = JSON.stringify(doc.components.schemas, null, 2);
+    schemas.appendChild(pre);
+    container.appendChild(schemas);
+  }
+});
+</script>
+</body>
+</html>
+`
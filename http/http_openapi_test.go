@@ -0,0 +1,175 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type alertRequest struct {
+	ChatID  string            `json:"chatid" doc:"wechat chat id"`
+	Content string            `json:"content"`
+	Labels  map[string]string `json:"labels,omitempty"`
+}
+
+type alertResponse struct {
+	ID      int64        `json:"id"`
+	SentAt  time.Time    `json:"sentAt"`
+	Request alertRequest `json:"request"`
+	Next    *alertResponse
+	secret  string
+}
+
+type searchHandler struct{}
+
+func (searchHandler) Serve(ctx context.Context, req *http.Request) (interface{}, int) {
+	return []alertResponse{}, 0
+}
+
+func TestHTTPServer_OpenAPI(t *testing.T) {
+	s := NewHTTPServer("localhost", 0, "/api")
+	s.RegisterApiHandler("/alerts", ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		return alertResponse{}, 0
+	}),
+		WithMethods(http.MethodPost),
+		WithSummary("Forward an alert", "Sends the alert to a wechat chat"),
+		WithTags("alerts"),
+		WithRequest(alertRequest{}),
+		WithResponse(http.StatusOK, alertResponse{}),
+		WithResponse(http.StatusUnauthorized, ErrorResponse{}))
+	s.RegisterApiHandler("/alerts/{id}", searchHandler{}, WithResponse(http.StatusOK, []alertResponse{}))
+	s.RegisterApiHandler("/alerts/{id}/ack", searchHandler{}, WithMethods(http.MethodGet, http.MethodPatch))
+	s.RegisterApiHandler("/alerts/id/ack", searchHandler{})
+	s.ServeOpenAPI("/openapi.json", "/docs", OpenAPIInfo{Title: "orb", Version: "1.0"})
+
+	rw := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	var doc map[string]interface{}
+	if err := json.NewDecoder(rw.Body).Decode(&doc); err != nil {
+		t.Fatalf("decode openapi error = %v", err)
+	}
+
+	lookup := func(path ...string) interface{} {
+		var v interface{} = doc
+		for _, p := range path {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return nil
+			}
+			v = m[p]
+		}
+		return v
+	}
+
+	tests := []struct {
+		name string
+		path []string
+		want interface{}
+	}{
+		{
+			name: "version",
+			path: []string{"openapi"},
+			want: "3.0.3",
+		},
+		{
+			name: "summary",
+			path: []string{"paths", "/api/alerts", "post", "summary"},
+			want: "Forward an alert",
+		},
+		{
+			name: "request body reference",
+			path: []string{"paths", "/api/alerts", "post", "requestBody", "content", "application/json", "schema", "$ref"},
+			want: "#/components/schemas/alertRequest",
+		},
+		{
+			name: "error response",
+			path: []string{"paths", "/api/alerts", "post", "responses", "401", "content", "application/json", "schema", "$ref"},
+			want: "#/components/schemas/ErrorResponse",
+		},
+		{
+			name: "operation id from method and path",
+			path: []string{"paths", "/api/alerts/{id}", "get", "operationId"},
+			want: "get_api_alerts_id",
+		},
+		{
+			name: "operation id of another method",
+			path: []string{"paths", "/api/alerts/{id}/ack", "patch", "operationId"},
+			want: "patch_api_alerts_id_ack",
+		},
+		{
+			name: "colliding operation id",
+			path: []string{"paths", "/api/alerts/id/ack", "get", "operationId"},
+			want: "get_api_alerts_id_ack_2",
+		},
+		{
+			name: "array response",
+			path: []string{"paths", "/api/alerts/{id}", "get", "responses", "200", "content", "application/json", "schema", "items", "$ref"},
+			want: "#/components/schemas/alertResponse",
+		},
+		{
+			name: "path parameter",
+			path: []string{"paths", "/api/alerts/{id}", "get", "parameters"},
+			want: []interface{}{map[string]interface{}{
+				"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "string"},
+			}},
+		},
+		{
+			name: "time field",
+			path: []string{"components", "schemas", "alertResponse", "properties", "sentAt"},
+			want: map[string]interface{}{"type": "string", "format": "date-time"},
+		},
+		{
+			name: "recursive field",
+			path: []string{"components", "schemas", "alertResponse", "properties", "Next", "$ref"},
+			want: "#/components/schemas/alertResponse",
+		},
+		{
+			name: "required fields",
+			path: []string{"components", "schemas", "alertRequest", "required"},
+			want: []interface{}{"chatid", "content"},
+		},
+		{
+			name: "documented field",
+			path: []string{"components", "schemas", "alertRequest", "properties", "chatid"},
+			want: map[string]interface{}{"type": "string", "description": "wechat chat id"},
+		},
+		{
+			name: "map field",
+			path: []string{"components", "schemas", "alertRequest", "properties", "labels", "additionalProperties", "type"},
+			want: "string",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := lookup(tt.path...); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%v = %v, want %v", strings.Join(tt.path, "."), got, tt.want)
+			}
+		})
+	}
+
+	if _, ok := lookup("components", "schemas", "alertResponse", "properties").(map[string]interface{})["secret"]; ok {
+		t.Errorf("unexported field is documented")
+	}
+
+	gen := &schemaGenerator{schemas: map[string]interface{}{"alertRequest": nil, "http.alertRequest": nil}}
+	if name := gen.uniqueName(reflect.TypeOf(alertRequest{})); name != "http.alertRequest2" {
+		t.Errorf("uniqueName() = %v, want http.alertRequest2", name)
+	}
+	gen = &schemaGenerator{operationIDs: make(map[string]bool)}
+	for _, want := range []string{"get_alerts_id", "get_alerts_id_2", "get_alerts_id_3"} {
+		if id := gen.operationID(http.MethodGet, "/alerts/{id}"); id != want {
+			t.Errorf("operationID() = %v, want %v", id, want)
+		}
+	}
+
+	rw = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if !strings.Contains(rw.Body.String(), `fetch("/openapi.json")`) {
+		t.Errorf("viewer does not load the spec")
+	}
+}
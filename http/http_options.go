@@ -0,0 +1,91 @@
+package http
+
+import (
+	"net"
+	"time"
+)
+
+const (
+	defaultReadTimeout       = 60 * time.Second
+	defaultReadHeaderTimeout = 10 * time.Second
+	defaultWriteTimeout      = 60 * time.Second
+	defaultIdleTimeout       = 120 * time.Second
+	defaultDrainTimeout      = 30 * time.Second
+	defaultShutdownTimeout   = 10 * time.Second
+)
+
+//ServerOption configures a HTTPServer
+type ServerOption func(*HTTPServer)
+
+//WithReadTimeout set the maximum duration for reading the entire request
+func WithReadTimeout(timeout time.Duration) ServerOption {
+	return func(s *HTTPServer) {
+		s.readTimeout = timeout
+	}
+}
+
+//WithReadHeaderTimeout set the maximum duration for reading the request headers
+func WithReadHeaderTimeout(timeout time.Duration) ServerOption {
+	return func(s *HTTPServer) {
+		s.readHeaderTimeout = timeout
+	}
+}
+
+//WithWriteTimeout set the maximum duration before timing out writes of the response
+func WithWriteTimeout(timeout time.Duration) ServerOption {
+	return func(s *HTTPServer) {
+		s.writeTimeout = timeout
+	}
+}
+
+//WithIdleTimeout set the maximum duration to wait for the next request on keep-alive connections
+func WithIdleTimeout(timeout time.Duration) ServerOption {
+	return func(s *HTTPServer) {
+		s.idleTimeout = timeout
+	}
+}
+
+//WithDrainTimeout set how long Shutdown waits for in-flight requests, 0 waits forever
+func WithDrainTimeout(timeout time.Duration) ServerOption {
+	return func(s *HTTPServer) {
+		s.drainTimeout = timeout
+	}
+}
+
+//WithShutdownHookTimeout set how long Shutdown waits for the shutdown hooks, they get
+//their own timeout since the drain timeout has often expired when they run
+func WithShutdownHookTimeout(timeout time.Duration) ServerOption {
+	return func(s *HTTPServer) {
+		s.shutdownTimeout = timeout
+	}
+}
+
+//WithListener serves on an existing listener instead of listening on host:port
+func WithListener(listener net.Listener) ServerOption {
+	return func(s *HTTPServer) {
+		s.listener = listener
+	}
+}
+
+//WithUnixSocket serves on a unix domain socket at path instead of host:port
+func WithUnixSocket(path string) ServerOption {
+	return func(s *HTTPServer) {
+		s.unixSocket = path
+	}
+}
+
+//WithAccessLog configures the access log of the routes, which logs the default
+//fields of AccessLogConfig when the option is not given
+func WithAccessLog(c AccessLogConfig) ServerOption {
+	return func(s *HTTPServer) {
+		s.accessLog = AccessLog(c)
+	}
+}
+
+//WithoutAccessLog disables the access log of the routes, e.g. when AccessLog is
+//registered with Use to also log the requests rejected by the server middlewares
+func WithoutAccessLog() ServerOption {
+	return func(s *HTTPServer) {
+		s.accessLog = nil
+	}
+}
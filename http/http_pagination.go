@@ -0,0 +1,226 @@
+package http
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultPageSize    = 20
+	defaultMaxPageSize = 100
+)
+
+//PageConfig limits the pages the clients can request
+type PageConfig struct {
+	//DefaultSize is the size of the pages requested without size, 20 by default
+	DefaultSize int
+	//MaxSize is the largest size, larger sizes are reduced to it, 100 by default
+	MaxSize int
+	//MaxOffset is the limit of offset plus size, unlimited by default,
+	//e.g. the max_result_window of an elasticsearch index
+	MaxOffset int
+}
+
+//Page is the page requested by the page and size or the cursor and size query parameters
+type Page struct {
+	//Page starts at 1, it is 0 for cursor pages
+	Page int
+	Size int
+	//Cursor is the opaque cursor of the request, empty for the first page
+	Cursor string
+}
+
+//Offset returns the index of the first item of the page
+func (p Page) Offset() int {
+	if p.Page < 1 {
+		return 0
+	}
+	return (p.Page - 1) * p.Size
+}
+
+//ParsePage parses the page and size query parameters, errors are *ErrorResponse with status 400
+func ParsePage(req *http.Request, c PageConfig) (Page, error) {
+	size, err := parseSize(req, c)
+	if err != nil {
+		return Page{}, err
+	}
+	page := Page{Page: 1, Size: size}
+	if value := req.URL.Query().Get("page"); len(value) > 0 {
+		if page.Page, err = strconv.Atoi(value); err != nil || page.Page < 1 {
+			return Page{}, &ErrorResponse{Code: http.StatusBadRequest, Message: "page must be a positive integer"}
+		}
+		//the offset of the page must not overflow
+		if page.Page > math.MaxInt/page.Size {
+			return Page{}, &ErrorResponse{Code: http.StatusBadRequest, Message: "page is too large"}
+		}
+	}
+	if c.MaxOffset > 0 && page.Offset()+page.Size > c.MaxOffset {
+		return Page{}, &ErrorResponse{Code: http.StatusBadRequest,
+			Message: "page must end within the first " + strconv.Itoa(c.MaxOffset) + " items"}
+	}
+	return page, nil
+}
+
+//ParseCursor parses the cursor and size query parameters, errors are *ErrorResponse with status 400
+func ParseCursor(req *http.Request, c PageConfig) (Page, error) {
+	size, err := parseSize(req, c)
+	if err != nil {
+		return Page{}, err
+	}
+	return Page{Size: size, Cursor: req.URL.Query().Get("cursor")}, nil
+}
+
+func parseSize(req *http.Request, c PageConfig) (int, error) {
+	if c.DefaultSize <= 0 {
+		c.DefaultSize = defaultPageSize
+	}
+	if c.MaxSize <= 0 {
+		c.MaxSize = defaultMaxPageSize
+	}
+	value := req.URL.Query().Get("size")
+	if len(value) == 0 {
+		return c.DefaultSize, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil || size < 1 {
+		return 0, &ErrorResponse{Code: http.StatusBadRequest, Message: "size must be a positive integer"}
+	}
+	if size > c.MaxSize {
+		size = c.MaxSize
+	}
+	return size, nil
+}
+
+//EncodeCursor encodes v, e.g. the sort values of the last item, as an opaque cursor
+func EncodeCursor(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+//DecodeCursor decodes a cursor encoded by EncodeCursor into v, errors are *ErrorResponse with status 400
+func DecodeCursor(cursor string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(data, v)
+	}
+	if err != nil {
+		return &ErrorResponse{Code: http.StatusBadRequest, Message: "invalid cursor"}
+	}
+	return nil
+}
+
+// ----------------------------------------------------------------------------
+// List response
+// ---------------------------------------------------------------------------
+
+//ListResponse is the envelope of list endpoints, Links holds the URLs of the
+//first, prev, next and last pages that exist, they are also set in the Link header
+type ListResponse struct {
+	Items      interface{}       `json:"items"`
+	Total      *int64            `json:"total,omitempty"`
+	Page       int               `json:"page,omitempty"`
+	Size       int               `json:"size"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+	Links      map[string]string `json:"links,omitempty"`
+}
+
+//NewPageList returns items as the page of total items requested by req
+func NewPageList(ctx context.Context, req *http.Request, page Page, items interface{}, total int64) *ListResponse {
+	list := &ListResponse{
+		Items: listItems(items),
+		Total: &total,
+		Page:  page.Page,
+		Size:  page.Size,
+		Links: make(map[string]string),
+	}
+
+	last := 1
+	if total > 0 && page.Size > 0 {
+		last = int((total + int64(page.Size) - 1) / int64(page.Size))
+	}
+	link := func(rel string, n int) {
+		list.Links[rel] = pageURL(req, map[string]string{"page": strconv.Itoa(n), "size": strconv.Itoa(page.Size)})
+	}
+	link("first", 1)
+	if page.Page > 1 {
+		link("prev", minInt(page.Page-1, last))
+	}
+	if page.Page < last {
+		link("next", page.Page+1)
+	}
+	link("last", last)
+
+	setLinkHeader(ctx, list.Links)
+	return list
+}
+
+//NewCursorList returns items as the page requested by req, nextCursor is empty on the last page
+func NewCursorList(ctx context.Context, req *http.Request, page Page, items interface{}, nextCursor string) *ListResponse {
+	list := &ListResponse{
+		Items:      listItems(items),
+		Size:       page.Size,
+		NextCursor: nextCursor,
+		Links:      make(map[string]string),
+	}
+	list.Links["first"] = pageURL(req, map[string]string{"cursor": "", "size": strconv.Itoa(page.Size)})
+	if len(nextCursor) > 0 {
+		list.Links["next"] = pageURL(req, map[string]string{"cursor": nextCursor, "size": strconv.Itoa(page.Size)})
+	}
+
+	setLinkHeader(ctx, list.Links)
+	return list
+}
+
+//listItems encodes nil slices as empty arrays rather than null
+func listItems(items interface{}) interface{} {
+	if items == nil {
+		return []interface{}{}
+	}
+	if v := reflect.ValueOf(items); v.Kind() == reflect.Slice && v.IsNil() {
+		return []interface{}{}
+	}
+	return items
+}
+
+//pageURL returns the path and query of req with params replaced, empty params are removed
+func pageURL(req *http.Request, params map[string]string) string {
+	query := req.URL.Query()
+	for key, value := range params {
+		if len(value) == 0 {
+			query.Del(key)
+			continue
+		}
+		query.Set(key, value)
+	}
+	if len(query) == 0 {
+		return req.URL.Path
+	}
+	return req.URL.Path + "?" + query.Encode()
+}
+
+//setLinkHeader sets the RFC 8288 Link header of links in a stable order
+func setLinkHeader(ctx context.Context, links map[string]string) {
+	var values []string
+	for _, rel := range []string{"first", "prev", "next", "last"} {
+		if url, ok := links[rel]; ok {
+			values = append(values, "<"+url+`>; rel="`+rel+`"`)
+		}
+	}
+	GetResponse(ctx).AddHeader("Link", strings.Join(values, ", "))
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,146 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	c := PageConfig{DefaultSize: 10, MaxSize: 50, MaxOffset: 100}
+	tests := []struct {
+		name    string
+		url     string
+		want    Page
+		wantErr bool
+	}{
+		{"defaults", "/alerts", Page{Page: 1, Size: 10}, false},
+		{"page and size", "/alerts?page=3&size=20", Page{Page: 3, Size: 20}, false},
+		{"size is clamped", "/alerts?size=500", Page{Page: 1, Size: 50}, false},
+		{"invalid page", "/alerts?page=0", Page{}, true},
+		{"invalid size", "/alerts?size=ten", Page{}, true},
+		{"beyond max offset", "/alerts?page=6&size=20", Page{}, true},
+		{"overflowing page", "/alerts?page=922337203685477581&size=20", Page{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePage(httptest.NewRequest(http.MethodGet, tt.url, nil), c)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParsePage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				if rsp, ok := err.(*ErrorResponse); !ok || rsp.Code != http.StatusBadRequest {
+					t.Errorf("ParsePage() error = %#v, want a 400 *ErrorResponse", err)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParsePage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCursor(t *testing.T) {
+	type position struct {
+		After []interface{} `json:"after"`
+	}
+	cursor, err := EncodeCursor(position{After: []interface{}{"2020-01-01", "a1"}})
+	if err != nil {
+		t.Fatalf("EncodeCursor() error = %v", err)
+	}
+
+	page, err := ParseCursor(httptest.NewRequest(http.MethodGet, "/alerts?cursor="+cursor, nil), PageConfig{})
+	if err != nil || page.Cursor != cursor || page.Size != defaultPageSize {
+		t.Fatalf("ParseCursor() = %+v, %v", page, err)
+	}
+	var got position
+	if err := DecodeCursor(page.Cursor, &got); err != nil || !reflect.DeepEqual(got.After, []interface{}{"2020-01-01", "a1"}) {
+		t.Errorf("DecodeCursor() = %+v, %v", got, err)
+	}
+	if err := DecodeCursor("not a cursor", &got); err == nil {
+		t.Errorf("DecodeCursor() error = nil, want invalid cursor")
+	}
+}
+
+func TestNewPageList(t *testing.T) {
+	tests := []struct {
+		name  string
+		url   string
+		items []string
+		total int64
+		links map[string]string
+		link  string
+	}{
+		{
+			name:  "middle page",
+			url:   "/api/alerts?page=2&size=2&state=open",
+			items: []string{"c", "d"},
+			total: 5,
+			links: map[string]string{
+				"first": "/api/alerts?page=1&size=2&state=open",
+				"prev":  "/api/alerts?page=1&size=2&state=open",
+				"next":  "/api/alerts?page=3&size=2&state=open",
+				"last":  "/api/alerts?page=3&size=2&state=open",
+			},
+			link: `</api/alerts?page=1&size=2&state=open>; rel="first", </api/alerts?page=1&size=2&state=open>; rel="prev", ` +
+				`</api/alerts?page=3&size=2&state=open>; rel="next", </api/alerts?page=3&size=2&state=open>; rel="last"`,
+		},
+		{
+			name:  "empty",
+			url:   "/api/alerts",
+			total: 0,
+			links: map[string]string{
+				"first": "/api/alerts?page=1&size=20",
+				"last":  "/api/alerts?page=1&size=20",
+			},
+			link: `</api/alerts?page=1&size=20>; rel="first", </api/alerts?page=1&size=20>; rel="last"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+				page, err := ParsePage(req, PageConfig{})
+				if err != nil {
+					return err, 0
+				}
+				return NewPageList(ctx, req, page, tt.items, tt.total), 0
+			})
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.url, nil))
+
+			var got struct {
+				Items []string          `json:"items"`
+				Total int64             `json:"total"`
+				Links map[string]string `json:"links"`
+			}
+			if err := json.Unmarshal(rw.Body.Bytes(), &got); err != nil {
+				t.Fatalf("decode %s error = %v", rw.Body.String(), err)
+			}
+			if got.Items == nil || len(got.Items) != len(tt.items) || got.Total != tt.total {
+				t.Errorf("body = %s, want %d items of %d", rw.Body.String(), len(tt.items), tt.total)
+			}
+			if !reflect.DeepEqual(got.Links, tt.links) {
+				t.Errorf("links = %v, want %v", got.Links, tt.links)
+			}
+			if link := rw.Header().Get("Link"); link != tt.link {
+				t.Errorf("Link = %s, want %s", link, tt.link)
+			}
+		})
+	}
+}
+
+func TestNewCursorList(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/alerts?cursor=abc&size=2", nil)
+	list := NewCursorList(context.Background(), req, Page{Size: 2, Cursor: "abc"}, []string{"a", "b"}, "def")
+	want := map[string]string{
+		"first": "/api/alerts?size=2",
+		"next":  "/api/alerts?cursor=def&size=2",
+	}
+	if list.Total != nil || list.NextCursor != "def" || !reflect.DeepEqual(list.Links, want) {
+		t.Errorf("NewCursorList() = %+v, want links %v", list, want)
+	}
+}
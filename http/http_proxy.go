@@ -0,0 +1,276 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	defaultProxyTimeout     = 30 * time.Second
+	defaultProxyMaxFails    = 3
+	defaultProxyFailTimeout = 30 * time.Second
+)
+
+//Upstream is a service the requests of a proxy route are forwarded to
+type Upstream struct {
+	//URL is the base URL, its path is prepended to the request path
+	URL string
+	//Timeout limits the whole exchange with the upstream, ProxyConfig.Timeout by default
+	Timeout time.Duration
+}
+
+//ProxyConfig configures a reverse proxy route
+type ProxyConfig struct {
+	//Upstreams are balanced round robin, skipping the upstreams marked down
+	Upstreams []Upstream
+	//StripPrefix removes the path of the route, including the API prefix, from the request path
+	StripPrefix bool
+	//Rewrite rewrites the request path after StripPrefix
+	Rewrite func(path string) string
+	//PreserveHost forwards the Host header of the client instead of the host of the upstream
+	PreserveHost bool
+	//SetHeaders are set on the forwarded requests, RemoveHeaders are removed from them
+	SetHeaders    map[string]string
+	RemoveHeaders []string
+	//SetResponseHeaders are set on the responses, RemoveResponseHeaders are removed from them
+	SetResponseHeaders    map[string]string
+	RemoveResponseHeaders []string
+	//Timeout is the default timeout of the upstreams, 30s by default
+	Timeout time.Duration
+	//MaxFails is the number of consecutive failures marking an upstream down, 3 by default.
+	//Failures are transport errors, timeouts and 502, 503 and 504 responses.
+	MaxFails int
+	//FailTimeout is how long an upstream stays down, 30s by default
+	FailTimeout time.Duration
+	//Transport sends the requests to the upstreams, http.DefaultTransport by default
+	Transport http.RoundTripper
+}
+
+//RegisterProxy forwards the requests of url to the upstreams of c. The route runs the
+//server and route middlewares and the access log like an ApiHandler. Requests are not
+//retried on another upstream since their body may already be consumed.
+func (s *HTTPServer) RegisterProxy(url string, c ProxyConfig, opts ...RouteOption) error {
+	if len(c.Upstreams) == 0 {
+		return errors.New("proxy route " + url + " has no upstream")
+	}
+	logging.Debug("mapping proxy", logging.Fields{
+		"prefix":    s.prefix,
+		"url":       url,
+		"upstreams": c.Upstreams,
+	})
+
+	route := newRoute(url, opts)
+	route.pattern = s.prefix + url
+	p, err := newProxy(c, route.pattern)
+	if err != nil {
+		return err
+	}
+
+	s.handle(route, "proxy", p, p)
+	return nil
+}
+
+type proxy struct {
+	upstreams   []*upstream
+	next        uint32
+	maxFails    int
+	failTimeout time.Duration
+	now         func() time.Time
+}
+
+type upstream struct {
+	url     *url.URL
+	timeout time.Duration
+	proxy   *httputil.ReverseProxy
+
+	mu        sync.Mutex
+	fails     int
+	downUntil time.Time
+}
+
+func newProxy(c ProxyConfig, pattern string) (*proxy, error) {
+	if c.Timeout <= 0 {
+		c.Timeout = defaultProxyTimeout
+	}
+	if c.MaxFails <= 0 {
+		c.MaxFails = defaultProxyMaxFails
+	}
+	if c.FailTimeout <= 0 {
+		c.FailTimeout = defaultProxyFailTimeout
+	}
+
+	//the route path ends before the first wildcard, e.g. /api/legacy/ of GET /api/legacy/{id}
+	routePath := pattern
+	if i := strings.Index(routePath, " "); i > 0 {
+		routePath = strings.TrimSpace(routePath[i+1:])
+	}
+	if i := strings.Index(routePath, "{"); i >= 0 {
+		routePath = routePath[:i]
+	}
+	routePath = strings.TrimSuffix(routePath, "/")
+
+	p := &proxy{
+		maxFails:    c.MaxFails,
+		failTimeout: c.FailTimeout,
+		now:         time.Now,
+	}
+	for _, config := range c.Upstreams {
+		target, err := url.Parse(config.URL)
+		if err != nil || len(target.Scheme) == 0 || len(target.Host) == 0 {
+			return nil, fmt.Errorf("invalid upstream url %q", config.URL)
+		}
+		u := &upstream{url: target, timeout: config.Timeout}
+		if u.timeout <= 0 {
+			u.timeout = c.Timeout
+		}
+		u.proxy = &httputil.ReverseProxy{
+			Director:       c.director(target, routePath),
+			Transport:      c.Transport,
+			FlushInterval:  -1,
+			ModifyResponse: p.modifyResponse(c, u),
+			ErrorHandler:   p.errorHandler(u),
+		}
+		p.upstreams = append(p.upstreams, u)
+	}
+	return p, nil
+}
+
+func (p *proxy) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	u := p.pick()
+	ctx, cancel := context.WithTimeout(r.Context(), u.timeout)
+	defer cancel()
+	u.proxy.ServeHTTP(rw, r.WithContext(ctx))
+}
+
+//pick returns the next upstream that is not down, or the next one if all are down
+func (p *proxy) pick() *upstream {
+	now := p.now()
+	n := uint32(len(p.upstreams))
+	start := atomic.AddUint32(&p.next, 1) - 1
+	for i := uint32(0); i < n; i++ {
+		u := p.upstreams[(start+i)%n]
+		if u.available(now) {
+			return u
+		}
+	}
+	return p.upstreams[start%n]
+}
+
+func (u *upstream) available(now time.Time) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return !now.Before(u.downUntil)
+}
+
+func (p *proxy) markFailed(u *upstream) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails++
+	if u.fails >= p.maxFails {
+		if p.now().After(u.downUntil) {
+			logging.Warn("upstream marked down", logging.Fields{
+				"upstream": u.url.String(),
+				"fails":    u.fails,
+			})
+		}
+		u.downUntil = p.now().Add(p.failTimeout)
+	}
+}
+
+func (p *proxy) markSucceeded(u *upstream) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.fails = 0
+	u.downUntil = time.Time{}
+}
+
+func (c ProxyConfig) director(target *url.URL, routePath string) func(*http.Request) {
+	return func(r *http.Request) {
+		path := r.URL.Path
+		if c.StripPrefix {
+			path = "/" + strings.TrimPrefix(strings.TrimPrefix(path, routePath), "/")
+		}
+		if c.Rewrite != nil {
+			path = c.Rewrite(path)
+		}
+
+		r.Header.Set("X-Forwarded-Host", r.Host)
+		if r.TLS != nil {
+			r.Header.Set("X-Forwarded-Proto", "https")
+		} else {
+			r.Header.Set("X-Forwarded-Proto", "http")
+		}
+
+		r.URL.Scheme = target.Scheme
+		r.URL.Host = target.Host
+		r.URL.Path = strings.TrimSuffix(target.Path, "/") + path
+		r.URL.RawPath = ""
+		if len(target.RawQuery) > 0 && len(r.URL.RawQuery) > 0 {
+			r.URL.RawQuery = target.RawQuery + "&" + r.URL.RawQuery
+		} else if len(target.RawQuery) > 0 {
+			r.URL.RawQuery = target.RawQuery
+		}
+		if !c.PreserveHost {
+			r.Host = target.Host
+		}
+
+		for _, key := range c.RemoveHeaders {
+			r.Header.Del(key)
+		}
+		for key, value := range c.SetHeaders {
+			r.Header.Set(key, value)
+		}
+		if _, ok := r.Header["User-Agent"]; !ok {
+			//keep the default user agent of the transport from being sent
+			r.Header.Set("User-Agent", "")
+		}
+	}
+}
+
+func (p *proxy) modifyResponse(c ProxyConfig, u *upstream) func(*http.Response) error {
+	return func(rsp *http.Response) error {
+		switch rsp.StatusCode {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			p.markFailed(u)
+		default:
+			p.markSucceeded(u)
+		}
+
+		for _, key := range c.RemoveResponseHeaders {
+			rsp.Header.Del(key)
+		}
+		for key, value := range c.SetResponseHeaders {
+			rsp.Header.Set(key, value)
+		}
+		return nil
+	}
+}
+
+func (p *proxy) errorHandler(u *upstream) func(http.ResponseWriter, *http.Request, error) {
+	return func(rw http.ResponseWriter, r *http.Request, err error) {
+		if r.Context().Err() == context.Canceled {
+			//the client went away, the upstream is not at fault
+			return
+		}
+		p.markFailed(u)
+		logging.Error("proxy request failed", logging.Fields{
+			"url":      r.RequestURI,
+			"upstream": u.url.String(),
+		}, err)
+		if r.Context().Err() == context.DeadlineExceeded {
+			WriteError(rw, http.StatusGatewayTimeout, "upstream timed out")
+			return
+		}
+		WriteError(rw, http.StatusBadGateway, "upstream unavailable")
+	}
+}
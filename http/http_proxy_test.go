@@ -0,0 +1,168 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer_RegisterProxy(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Header().Set("X-Path", r.URL.RequestURI())
+		rw.Header().Set("X-Tenant", r.Header.Get("X-Tenant"))
+		rw.Header().Set("X-Cookie", r.Header.Get("Cookie"))
+		rw.Header().Set("X-Caller", r.Header.Get("X-Caller"))
+		rw.Header().Set("Server", "legacy")
+		rw.Write([]byte("legacy"))
+	}))
+	defer upstream.Close()
+
+	tests := []struct {
+		name   string
+		config ProxyConfig
+		url    string
+		path   string
+	}{
+		{
+			name:   "keeps the path",
+			config: ProxyConfig{Upstreams: []Upstream{{URL: upstream.URL}}},
+			url:    "/api/legacy/alerts?state=open",
+			path:   "/api/legacy/alerts?state=open",
+		},
+		{
+			name:   "strips the route path",
+			config: ProxyConfig{Upstreams: []Upstream{{URL: upstream.URL + "/v1"}}, StripPrefix: true},
+			url:    "/api/legacy/alerts?state=open",
+			path:   "/v1/alerts?state=open",
+		},
+		{
+			name: "rewrites the path",
+			config: ProxyConfig{
+				Upstreams:   []Upstream{{URL: upstream.URL}},
+				StripPrefix: true,
+				Rewrite:     func(path string) string { return "/legacy.php" + path },
+			},
+			url:  "/api/legacy/alerts",
+			path: "/legacy.php/alerts",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.SetHeaders = map[string]string{"X-Tenant": "ops"}
+			tt.config.RemoveHeaders = []string{"Cookie"}
+			tt.config.RemoveResponseHeaders = []string{"Server"}
+			tt.config.SetResponseHeaders = map[string]string{"X-Proxy": "orb"}
+
+			s := NewHTTPServer("localhost", 0, "/api", WithoutAccessLog())
+			s.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+					r.Header.Set("X-Caller", "gateway")
+					next.ServeHTTP(rw, r)
+				})
+			})
+			if err := s.RegisterProxy("/legacy/", tt.config); err != nil {
+				t.Fatalf("RegisterProxy() error = %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("Cookie", "session=1")
+			rw := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rw, req)
+
+			if rw.Code != http.StatusOK || rw.Body.String() != "legacy" {
+				t.Fatalf("status = %d, body %s", rw.Code, rw.Body.String())
+			}
+			want := map[string]string{
+				"X-Path":   tt.path,
+				"X-Tenant": "ops",
+				"X-Cookie": "",
+				"X-Caller": "gateway",
+				"Server":   "",
+				"X-Proxy":  "orb",
+			}
+			for key, value := range want {
+				if got := rw.Header().Get(key); got != value {
+					t.Errorf("%s = %q, want %q", key, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestHTTPServer_RegisterProxyBalancing(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.Write([]byte("healthy"))
+	}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		rw.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	s := NewHTTPServer("localhost", 0, "", WithoutAccessLog())
+	err := s.RegisterProxy("/legacy/", ProxyConfig{
+		Upstreams:   []Upstream{{URL: healthy.URL}, {URL: failing.URL}},
+		MaxFails:    2,
+		FailTimeout: time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("RegisterProxy() error = %v", err)
+	}
+
+	var statuses []int
+	for i := 0; i < 8; i++ {
+		rw := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/legacy/alerts", nil))
+		statuses = append(statuses, rw.Code)
+	}
+	//round robin until the failing upstream is marked down after its second 503
+	want := []int{200, 503, 200, 503, 200, 200, 200, 200}
+	for i := range want {
+		if statuses[i] != want[i] {
+			t.Fatalf("statuses = %v, want %v", statuses, want)
+		}
+	}
+}
+
+func TestHTTPServer_RegisterProxyErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	tests := []struct {
+		name     string
+		upstream Upstream
+		status   int
+	}{
+		{"upstream timeout", Upstream{URL: slow.URL, Timeout: 50 * time.Millisecond}, http.StatusGatewayTimeout},
+		{"upstream unavailable", Upstream{URL: closed.URL}, http.StatusBadGateway},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewHTTPServer("localhost", 0, "", WithoutAccessLog())
+			if err := s.RegisterProxy("/legacy/", ProxyConfig{Upstreams: []Upstream{tt.upstream}}); err != nil {
+				t.Fatalf("RegisterProxy() error = %v", err)
+			}
+			rw := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/legacy/alerts", nil))
+			if rw.Code != tt.status {
+				t.Errorf("status = %d, want %d, body %s", rw.Code, tt.status, rw.Body.String())
+			}
+		})
+	}
+
+	s := NewHTTPServer("localhost", 0, "", WithoutAccessLog())
+	if err := s.RegisterProxy("/legacy/", ProxyConfig{Upstreams: []Upstream{{URL: "legacy:8080"}}}); err == nil {
+		t.Errorf("RegisterProxy() error = nil, want invalid upstream url")
+	}
+	if err := s.RegisterProxy("/legacy/", ProxyConfig{}); err == nil {
+		t.Errorf("RegisterProxy() error = nil, want no upstream")
+	}
+}
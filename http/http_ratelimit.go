@@ -0,0 +1,231 @@
+package http
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v-zhidu/orb/config"
+	"github.com/v-zhidu/orb/logging"
+)
+
+const rateLimitCleanupInterval = time.Minute
+
+//RateLimit is a token bucket refilled by Rate tokens per second up to Burst tokens,
+//a zero Rate disables the limit
+type RateLimit struct {
+	Rate  float64 `mapstructure:"rate"`
+	Burst int     `mapstructure:"burst"`
+}
+
+//RateLimitConfig is the default limit and the per-route overrides keyed by path prefix
+type RateLimitConfig struct {
+	Default RateLimit            `mapstructure:"default"`
+	Routes  map[string]RateLimit `mapstructure:"routes"`
+}
+
+//RateLimitConfigFromConfig loads a RateLimitConfig from the configuration under configKey
+func RateLimitConfigFromConfig(configKey string) (RateLimitConfig, error) {
+	var c RateLimitConfig
+	if err := config.Unmarshal(configKey, &c); err != nil {
+		logging.Error("failed to load rate limit config", logging.Fields{
+			"key": configKey,
+		}, err)
+		return c, err
+	}
+	return c, nil
+}
+
+//limitFor returns the limit of the longest route prefix matching path
+func (c RateLimitConfig) limitFor(path string) (string, RateLimit) {
+	scope, limit := "", c.Default
+	for prefix, l := range c.Routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > len(scope) {
+			scope, limit = prefix, l
+		}
+	}
+	return scope, limit
+}
+
+//RateLimitResult is the outcome of taking a token from a bucket
+type RateLimitResult struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+//RateLimitStore keeps the token buckets, implement it to share limits between processes
+type RateLimitStore interface {
+	Take(key string, limit RateLimit) (RateLimitResult, error)
+}
+
+//RateLimitKeyFunc returns the identity of the caller a request is limited by
+type RateLimitKeyFunc func(req *http.Request) string
+
+//ClientIPKey limits requests by the remote ip address
+func ClientIPKey(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+//APIKeyKey limits requests by the api key authenticated by an APIKeyAuthenticator, or the
+//client ip for the other requests. The raw X-API-Key header is never used since clients
+//would get a new bucket for every made up key, so Authenticate must run first.
+func APIKeyKey(req *http.Request) string {
+	if principal := GetPrincipal(req.Context()); principal != nil && principal.Method == "apikey" {
+		return "key:" + principal.ID
+	}
+	return "ip:" + ClientIPKey(req)
+}
+
+//PrincipalRateLimitKey limits requests by the authenticated principal, or the client ip for anonymous requests
+func PrincipalRateLimitKey(req *http.Request) string {
+	if principal := GetPrincipal(req.Context()); principal != nil {
+		return "principal:" + principal.Method + ":" + principal.ID
+	}
+	return "ip:" + ClientIPKey(req)
+}
+
+//RateLimiter is a server middleware limiting the requests of each caller per route,
+//rejected requests get 429 with Retry-After and the ErrorResponse envelope
+func RateLimiter(c RateLimitConfig, key RateLimitKeyFunc, store RateLimitStore) Middleware {
+	if key == nil {
+		key = ClientIPKey
+	}
+	if store == nil {
+		store = NewMemoryRateLimitStore()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+			scope, limit := c.limitFor(r.URL.Path)
+			if limit.Rate <= 0 {
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			result, err := store.Take(scope+"|"+key(r), limit)
+			if err != nil {
+				//fail open, an unavailable store must not take the service down
+				logging.WithError("rate limit store error", err)
+				next.ServeHTTP(rw, r)
+				return
+			}
+
+			header := rw.Header()
+			header.Set("RateLimit-Limit", strconv.Itoa(limit.burst()))
+			header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			header.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+			if !result.Allowed {
+				//the caller key may carry credentials, the principal is logged instead
+				fields := logging.Fields{
+					"url":   r.RequestURI,
+					"ip":    ClientIPKey(r),
+					"scope": scope,
+				}
+				if principal := GetPrincipal(r.Context()); principal != nil {
+					fields["principal"] = principal.Method + ":" + principal.ID
+				}
+				logging.Warn("request rate limited", fields)
+				header.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+				WriteError(rw, http.StatusTooManyRequests, "rate limit exceeded")
+				return
+			}
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+func (l RateLimit) burst() int {
+	if l.Burst < 1 {
+		return 1
+	}
+	return l.Burst
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// ----------------------------------------------------------------------------
+// In-memory store
+// ---------------------------------------------------------------------------
+
+type tokenBucket struct {
+	tokens float64
+	burst  float64
+	rate   float64
+	last   time.Time
+}
+
+//MemoryRateLimitStore keeps token buckets in the memory of the process
+type MemoryRateLimitStore struct {
+	sync.Mutex
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	now         func() time.Time
+}
+
+//NewMemoryRateLimitStore returns an empty MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	return &MemoryRateLimitStore{
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+		now:         time.Now,
+	}
+}
+
+//Take implement RateLimitStore interface
+func (s *MemoryRateLimitStore) Take(key string, limit RateLimit) (RateLimitResult, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := s.now()
+	burst := float64(limit.burst())
+	s.cleanup(now)
+
+	bucket, ok := s.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: burst, last: now}
+		s.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(burst, bucket.tokens+now.Sub(bucket.last).Seconds()*limit.Rate)
+	bucket.burst, bucket.rate, bucket.last = burst, limit.Rate, now
+
+	result := RateLimitResult{}
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = rateDuration(1-bucket.tokens, limit.Rate)
+	}
+	result.Remaining = int(bucket.tokens)
+	result.Reset = rateDuration(burst-bucket.tokens, limit.Rate)
+
+	return result, nil
+}
+
+//cleanup drops the buckets that have been idle long enough to be full again
+func (s *MemoryRateLimitStore) cleanup(now time.Time) {
+	if now.Sub(s.lastCleanup) < rateLimitCleanupInterval {
+		return
+	}
+	s.lastCleanup = now
+	for key, bucket := range s.buckets {
+		if now.Sub(bucket.last) >= rateDuration(bucket.burst-bucket.tokens, bucket.rate) {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+func rateDuration(tokens float64, rate float64) time.Duration {
+	return time.Duration(tokens / rate * float64(time.Second))
+}
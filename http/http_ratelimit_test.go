@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestMemoryRateLimitStore_Take(t *testing.T) {
+	now := time.Unix(1000, 0)
+	store := NewMemoryRateLimitStore()
+	store.now = func() time.Time { return now }
+	limit := RateLimit{Rate: 1, Burst: 2}
+
+	tests := []struct {
+		name          string
+		advance       time.Duration
+		wantAllowed   bool
+		wantRemaining int
+	}{
+		{name: "first token", wantAllowed: true, wantRemaining: 1},
+		{name: "burst token", wantAllowed: true, wantRemaining: 0},
+		{name: "bucket empty", wantAllowed: false, wantRemaining: 0},
+		{name: "refilled after one second", advance: time.Second, wantAllowed: true, wantRemaining: 0},
+		{name: "refill capped by burst", advance: time.Hour, wantAllowed: true, wantRemaining: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			now = now.Add(tt.advance)
+			got, err := store.Take("client", limit)
+			if err != nil {
+				t.Fatalf("Take() error = %v", err)
+			}
+			if got.Allowed != tt.wantAllowed || got.Remaining != tt.wantRemaining {
+				t.Errorf("Take() = %+v, want allowed %v remaining %v", got, tt.wantAllowed, tt.wantRemaining)
+			}
+			if !got.Allowed && got.RetryAfter != time.Second {
+				t.Errorf("Take() retry after = %v, want 1s", got.RetryAfter)
+			}
+		})
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	s := NewHTTPServer("localhost", 0, "/api")
+	s.Use(Authenticate(NewAPIKeyAuthenticator([]APIKey{{Key: "a", Name: "a"}, {Key: "b", Name: "b"}, {Key: "c", Name: "c"}})))
+	s.Use(RateLimiter(RateLimitConfig{
+		Default: RateLimit{Rate: 1, Burst: 2},
+		Routes: map[string]RateLimit{
+			"/api/search":     {Rate: 0.1, Burst: 1},
+			"/api/search/raw": {},
+		},
+	}, APIKeyKey, nil))
+	ok := ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		return "ok", 0
+	})
+	s.RegisterApiHandler("/alerts", ok)
+	s.RegisterApiHandler("/search", ok)
+	s.RegisterApiHandler("/search/raw", ok)
+
+	do := func(url string, key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if len(key) > 0 {
+			req.Header.Set(APIKeyHeader, key)
+		}
+		rw := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rw, req)
+		return rw
+	}
+
+	for i := 0; i < 2; i++ {
+		if rw := do("/api/alerts", "a"); rw.Code != http.StatusOK {
+			t.Fatalf("request %v code = %v", i, rw.Code)
+		}
+	}
+	rw := do("/api/alerts", "a")
+	if rw.Code != http.StatusTooManyRequests {
+		t.Fatalf("limited request code = %v, want %v", rw.Code, http.StatusTooManyRequests)
+	}
+	if rw.Header().Get("Retry-After") != "1" || rw.Header().Get("RateLimit-Limit") != "2" ||
+		rw.Header().Get("RateLimit-Remaining") != "0" {
+		t.Errorf("limited request headers = %v", rw.Header())
+	}
+	var errRes ErrorResponse
+	if err := json.NewDecoder(rw.Body).Decode(&errRes); err != nil || errRes.Code != http.StatusTooManyRequests {
+		t.Errorf("limited request body = %+v, err %v", errRes, err)
+	}
+
+	if rw := do("/api/alerts", "b"); rw.Code != http.StatusOK {
+		t.Errorf("other api key code = %v, want %v", rw.Code, http.StatusOK)
+	}
+
+	//unverified keys never get their own bucket
+	limiter := RateLimiter(RateLimitConfig{Default: RateLimit{Rate: 1, Burst: 1}}, APIKeyKey, nil)(
+		http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {}))
+	for i, key := range []string{"random-1", "random-2"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/alerts", nil)
+		req.Header.Set(APIKeyHeader, key)
+		rw := httptest.NewRecorder()
+		limiter.ServeHTTP(rw, req)
+		if want := []int{http.StatusOK, http.StatusTooManyRequests}[i]; rw.Code != want {
+			t.Errorf("unverified key %s code = %v, want %v", key, rw.Code, want)
+		}
+	}
+
+	do("/api/search", "c")
+	if rw := do("/api/search", "c"); rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") != "10" {
+		t.Errorf("route override code = %v, retry after %v", rw.Code, rw.Header().Get("Retry-After"))
+	}
+	for i := 0; i < 5; i++ {
+		if rw := do("/api/search/raw", "c"); rw.Code != http.StatusOK || len(rw.Header().Get("RateLimit-Limit")) > 0 {
+			t.Fatalf("unlimited route code = %v, headers %v", rw.Code, rw.Header())
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+//Response controls the status, headers and cookies of the response of an ApiHandler from
+//its context. The changes are applied when the response is written, after the encoder
+//set its headers, so that they also apply to e.g. the Content-Type of the encoder and
+//to the error responses written for the returned value.
+type Response struct {
+	mu     sync.Mutex
+	status int
+	ops    []func(http.Header)
+	hooks  []func(status int, header http.Header)
+}
+
+//GetResponse returns the response of the ApiHandler of ctx, outside of an ApiHandler
+//it returns a new response so that the changes are discarded
+func GetResponse(ctx context.Context) *Response {
+	if ctx != nil {
+		if rsp, ok := ctx.Value(HeaderKey).(*Response); ok {
+			return rsp
+		}
+	}
+	return &Response{}
+}
+
+//SetHeader sets the response header key to value, replacing the values set by middlewares or the encoder
+func SetHeader(ctx context.Context, key string, value string) {
+	GetResponse(ctx).SetHeader(key, value)
+}
+
+//SetHeader replaces the values of the header key with value
+func (r *Response) SetHeader(key string, value string) {
+	r.op(func(h http.Header) {
+		h.Set(key, value)
+	})
+}
+
+//AddHeader adds value to the values of the header key
+func (r *Response) AddHeader(key string, value string) {
+	r.op(func(h http.Header) {
+		h.Add(key, value)
+	})
+}
+
+//DelHeader removes the header key, e.g. the Content-Type set by the encoder
+func (r *Response) DelHeader(key string) {
+	r.op(func(h http.Header) {
+		h.Del(key)
+	})
+}
+
+//SetCookie adds a Set-Cookie header, it returns an error for an invalid cookie
+func (r *Response) SetCookie(cookie *http.Cookie) error {
+	if err := cookie.Valid(); err != nil {
+		return err
+	}
+	value := cookie.String()
+	r.op(func(h http.Header) {
+		h.Add("Set-Cookie", value)
+	})
+	return nil
+}
+
+//ClearCookie tells the client to delete the cookie name of path and domain
+func (r *Response) ClearCookie(name string, path string, domain string) error {
+	return r.SetCookie(&http.Cookie{
+		Name:    name,
+		Path:    path,
+		Domain:  domain,
+		Expires: time.Unix(0, 0),
+		MaxAge:  -1,
+	})
+}
+
+//SetStatus sets the status of the response, it only replaces 200, the status of the error
+//and redirect responses is kept. The status returned by an ApiHandler is ignored.
+func (r *Response) SetStatus(status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status = status
+}
+
+//BeforeWrite registers a hook run just before the headers are written, after the changes
+//made with the other methods. Hooks run in registration order.
+func (r *Response) BeforeWrite(hook func(status int, header http.Header)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+func (r *Response) op(op func(http.Header)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+//writer returns rw applying the changes of r before the headers are written
+func (r *Response) writer(rw http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: rw, response: r}
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	response    *Response
+	wroteHeader bool
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+
+	r := w.response
+	r.mu.Lock()
+	if r.status != 0 && code == http.StatusOK {
+		code = r.status
+	}
+	ops := append([]func(http.Header){}, r.ops...)
+	hooks := append([]func(int, http.Header){}, r.hooks...)
+	r.mu.Unlock()
+
+	header := w.ResponseWriter.Header()
+	for _, op := range ops {
+		op(header)
+	}
+	for _, hook := range hooks {
+		hook(code, header)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+//Flush implement http.Flusher interface if the underlying writer supports it
+func (w *responseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+//Unwrap returns the underlying writer, used by http.ResponseController
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,127 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestResponse(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler ApiHandlerFunc
+		status  int
+		header  http.Header
+	}{
+		{
+			name: "multi valued headers",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				rsp := GetResponse(ctx)
+				rsp.AddHeader("X-Tag", "a")
+				rsp.AddHeader("X-Tag", "b")
+				rsp.SetHeader("X-Single", "a")
+				rsp.SetHeader("X-Single", "b")
+				return "ok", 0
+			},
+			status: http.StatusOK,
+			header: http.Header{"X-Tag": {"a", "b"}, "X-Single": {"b"}},
+		},
+		{
+			name: "set and delete encoder headers",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				GetResponse(ctx).SetHeader("Content-Type", "application/problem+json")
+				GetResponse(ctx).DelHeader("Vary")
+				return "ok", 0
+			},
+			status: http.StatusOK,
+			header: http.Header{"Content-Type": {"application/problem+json"}, "Vary": nil},
+		},
+		{
+			name: "cookies",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				rsp := GetResponse(ctx)
+				rsp.SetCookie(&http.Cookie{Name: "session", Value: "s1", Path: "/", MaxAge: 3600,
+					HttpOnly: true, Secure: true, SameSite: http.SameSiteStrictMode})
+				rsp.ClearCookie("legacy", "/api", "")
+				if err := rsp.SetCookie(&http.Cookie{Name: "bad name", Value: "v"}); err == nil {
+					t.Error("SetCookie() of an invalid cookie error = nil")
+				}
+				return "ok", 0
+			},
+			status: http.StatusOK,
+			header: http.Header{"Set-Cookie": {
+				"session=s1; Path=/; Max-Age=3600; HttpOnly; Secure; SameSite=Strict",
+				"legacy=; Path=/api; Expires=Thu, 01 Jan 1970 00:00:00 GMT; Max-Age=0",
+			}},
+		},
+		{
+			name: "set status",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				GetResponse(ctx).SetStatus(http.StatusAccepted)
+				return "accepted", 0
+			},
+			status: http.StatusAccepted,
+		},
+		{
+			name: "returned status is ignored",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				return "created", http.StatusCreated
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "error responses keep their status",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				GetResponse(ctx).SetStatus(http.StatusCreated)
+				GetResponse(ctx).SetHeader("Retry-After", "10")
+				return &ErrorResponse{Code: http.StatusServiceUnavailable, Message: "busy"}, 0
+			},
+			status: http.StatusServiceUnavailable,
+			header: http.Header{"Retry-After": {"10"}},
+		},
+		{
+			name: "before write hooks",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				rsp := GetResponse(ctx)
+				rsp.BeforeWrite(func(status int, header http.Header) {
+					header.Set("X-Status", http.StatusText(status))
+					header.Set("X-Content-Type", header.Get("Content-Type"))
+				})
+				rsp.BeforeWrite(func(status int, header http.Header) {
+					header.Add("X-Status", "second")
+				})
+				rsp.SetStatus(http.StatusCreated)
+				return "created", 0
+			},
+			status: http.StatusCreated,
+			header: http.Header{"X-Status": {"Created", "second"}, "X-Content-Type": {"application/json"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rw := httptest.NewRecorder()
+			tt.handler.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/alerts", nil))
+
+			if rw.Code != tt.status {
+				t.Errorf("status = %d, want %d", rw.Code, tt.status)
+			}
+			for key, values := range tt.header {
+				if got := rw.Header().Values(key); !reflect.DeepEqual(got, values) && len(got)+len(values) > 0 {
+					t.Errorf("%s = %q, want %q", key, got, values)
+				}
+			}
+		})
+	}
+}
+
+func TestGetResponse_Detached(t *testing.T) {
+	//changes made outside of an ApiHandler are discarded rather than panicking
+	ctx := context.Background()
+	SetHeader(ctx, "X-Caller", "job")
+	GetResponse(nil).SetStatus(http.StatusCreated)
+	if rsp := GetResponse(ctx); rsp == GetResponse(ctx) || len(rsp.ops) != 0 || rsp.status != 0 {
+		t.Error("GetResponse() outside of an ApiHandler keeps the changes")
+	}
+}
@@ -0,0 +1,121 @@
+package http
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"time"
+)
+
+//RouteOption configures a single route registered on HTTPServer
+type RouteOption func(*route)
+
+type route struct {
+	url         string
+	pattern     string
+	kind        string
+	handlerType reflect.Type
+	middlewares []Middleware
+
+	methods     []string
+	summary     string
+	description string
+	tags        []string
+	request     reflect.Type
+	responses   map[int]reflect.Type
+	timeout     time.Duration
+
+	heartbeat      time.Duration
+	bufferedStream bool
+	sendBuffer     int
+	pingInterval   time.Duration
+	checkOrigin    func(*http.Request) bool
+	spa            bool
+	hashedAssets   *regexp.Regexp
+
+	version     string
+	deprecation *deprecation
+}
+
+func newRoute(url string, opts []RouteOption) *route {
+	r := &route{
+		url:       url,
+		responses: make(map[int]reflect.Type),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	return r
+}
+
+//handle registers the route on the mux and keeps its metadata
+func (s *HTTPServer) handle(r *route, kind string, handler interface{}, h http.Handler) {
+	if len(r.pattern) == 0 {
+		r.pattern = s.prefix + r.url
+	}
+	r.kind = kind
+	r.handlerType = reflect.TypeOf(handler)
+
+	s.mu.Lock()
+	s.routes = append(s.routes, r)
+	s.mu.Unlock()
+
+	//api handlers answer at the deadline themselves, see RegisterApiHandler
+	if r.timeout > 0 && kind != "api" {
+		h = deadlineHandler(r.timeout, h)
+	}
+	if r.deprecation != nil {
+		h = deprecationHandler(r, h)
+	}
+	h = chain(h, r.middlewares...)
+	//the requests rejected by the route middlewares, e.g. RequireAuth, are logged too
+	if s.accessLog != nil {
+		h = s.accessLog(h)
+	}
+	s.mux.Handle(r.pattern, h)
+}
+
+//WithRouteMiddleware wraps only this route with middlewares,
+//they run after the server middlewares registered by Use
+func WithRouteMiddleware(middlewares ...Middleware) RouteOption {
+	return func(r *route) {
+		r.middlewares = append(r.middlewares, middlewares...)
+	}
+}
+
+//WithMethods documents the http methods served by the route, GET by default
+func WithMethods(methods ...string) RouteOption {
+	return func(r *route) {
+		r.methods = append(r.methods, methods...)
+	}
+}
+
+//WithSummary documents the route with a summary and an optional description
+func WithSummary(summary string, description string) RouteOption {
+	return func(r *route) {
+		r.summary = summary
+		r.description = description
+	}
+}
+
+//WithTags groups the route in the API documentation
+func WithTags(tags ...string) RouteOption {
+	return func(r *route) {
+		r.tags = append(r.tags, tags...)
+	}
+}
+
+//WithRequest documents the JSON request body of the route by an example value, e.g. AlertRequest{}
+func WithRequest(v interface{}) RouteOption {
+	return func(r *route) {
+		r.request = reflect.TypeOf(v)
+	}
+}
+
+//WithResponse documents the JSON response of the route for the status code by an example value,
+//nil documents a response without body
+func WithResponse(status int, v interface{}) RouteOption {
+	return func(r *route) {
+		r.responses[status] = reflect.TypeOf(v)
+	}
+}
@@ -1,15 +1,19 @@
 package http
 
 import (
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"reflect"
 	"strconv"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/v-zhidu/orb/health"
 	"github.com/v-zhidu/orb/logging"
 	context "golang.org/x/net/context"
 )
@@ -17,8 +21,13 @@ import (
 type contextKey string
 
 const (
-	//HeaderKey context key
+	//HeaderKey is the context key of the *Response of an ApiHandler, see GetResponse
 	HeaderKey = contextKey("headers")
+
+	//LivenessPath is the path of the built-in liveness endpoint
+	LivenessPath = "/healthz"
+	//ReadinessPath is the path of the built-in readiness endpoint
+	ReadinessPath = "/readyz"
 )
 
 type ApiHandler interface {
@@ -33,23 +42,22 @@ func (f ApiHandlerFunc) Serve(ctx context.Context, req *http.Request) (interface
 
 // ServeHTTP implement http.handler interface
 func (f ApiHandlerFunc) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
-	ctx := context.WithValue(context.Background(), HeaderKey, newHTTPHeaders())
+	//the handler context is cancelled when the client disconnects, the route deadline
+	//expires or the server gives up draining, and carries the span and principal
+	response := &Response{}
+	ctx := context.WithValue(r.Context(), HeaderKey, response)
+	//resources of the request, e.g. uploaded temp files, are released once the response is written
+	cleanup := &cleanups{}
+	ctx = context.WithValue(ctx, cleanupKey, cleanup)
+	defer cleanup.run()
 
 	rsp, _ := f.Serve(ctx, r)
-
-	header, _ := ctx.Value(HeaderKey).(*httpHeaders)
-	if header != nil {
-		headers := header.getHeader()
-		for headerkey, headervalue := range headers {
-			rw.Header().Add(headerkey, headervalue)
-		}
+	if err := ctx.Err(); err != nil {
+		writeContextError(rw, r, err)
+		return
 	}
 
-	encoder := json.NewEncoder(rw)
-	encoder.SetEscapeHTML(false)
-	if err := encoder.Encode(rsp); err != nil {
-		http.Error(rw, "Internal Error", http.StatusInternalServerError)
-	}
+	writeResponse(response.writer(rw), r, rsp)
 }
 
 // ----------------------------------------------------------------------------
@@ -57,22 +65,73 @@ func (f ApiHandlerFunc) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
 // ----------------------------------------------------------------------------
 
 type HTTPServer struct {
-	mux    *http.ServeMux
-	host   string
-	port   int
-	prefix string
+	mux         *http.ServeMux
+	host        string
+	port        int
+	prefix      string
+	middlewares []Middleware
+	accessLog   Middleware
+	health      *health.Registry
+
+	readTimeout       time.Duration
+	readHeaderTimeout time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	drainTimeout      time.Duration
+	shutdownTimeout   time.Duration
+	listener          net.Listener
+	unixSocket        string
+	h2c               bool
+	extraListeners    []ListenerConfig
+	versioning        VersioningConfig
+	tlsConfig         *TLSConfig
+	admin             *AdminConfig
+
+	mu            sync.Mutex
+	listeners     []net.Listener
+	servers       []*http.Server
+	adminServer   *http.Server
+	startTime     time.Time
+	cancelServing context.CancelFunc
+	shutdownHooks []func(context.Context) error
+	shutdownOnce  sync.Once
+	shutdownErr   error
+	done          chan struct{}
+
+	routes          []*route
+	versions        map[string]*versionSelector
+	websockets      map[*WebSocketConn]bool
+	websocketsGroup sync.WaitGroup
+	h2cGroup        sync.WaitGroup
+	h2cClosed       bool
 }
 
-func NewHTTPServer(host string, port int, prefix string) *HTTPServer {
-	return &HTTPServer{
-		mux:    http.NewServeMux(),
-		host:   host,
-		port:   port,
-		prefix: prefix,
+func NewHTTPServer(host string, port int, prefix string, opts ...ServerOption) *HTTPServer {
+	s := &HTTPServer{
+		mux:               http.NewServeMux(),
+		host:              host,
+		port:              port,
+		prefix:            prefix,
+		accessLog:         AccessLog(AccessLogConfig{}),
+		health:            health.NewRegistry(),
+		readTimeout:       defaultReadTimeout,
+		readHeaderTimeout: defaultReadHeaderTimeout,
+		writeTimeout:      defaultWriteTimeout,
+		idleTimeout:       defaultIdleTimeout,
+		drainTimeout:      defaultDrainTimeout,
+		shutdownTimeout:   defaultShutdownTimeout,
+		done:              make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
+	s.mux.Handle(LivenessPath, s.health.LivenessHandler())
+	s.mux.Handle(ReadinessPath, s.health.ReadinessHandler())
+
+	return s
 }
 
-func (s *HTTPServer) RegisterApiHandler(url string, handler ApiHandler) {
+func (s *HTTPServer) RegisterApiHandler(url string, handler ApiHandler, opts ...RouteOption) {
 	if len(url) == 0 {
 		logging.Errorln("register url is invalid")
 	}
@@ -82,107 +141,270 @@ func (s *HTTPServer) RegisterApiHandler(url string, handler ApiHandler) {
 		"url":     url,
 		"handler": reflect.TypeOf(handler),
 	})
-	s.mux.Handle(fmt.Sprintf("%s%s", s.prefix, url), loggingHandler(handler))
+	route := newRoute(url, opts)
+	var h http.Handler = ApiHandlerFunc(handler.Serve)
+	if route.timeout > 0 {
+		h = timeoutHandler(route.timeout, h)
+	}
+	s.handle(route, "api", handler, h)
 }
 
-func (s *HTTPServer) Run() {
-	server := &http.Server{
-		Handler:     s.mux,
-		Addr:        fmt.Sprintf("%s:%s", s.host, strconv.Itoa(s.port)),
-		ReadTimeout: 60 * time.Second,
+//AddLivenessCheck registers a check reported by /healthz and /readyz
+func (s *HTTPServer) AddLivenessCheck(name string, checker health.Checker, opts ...health.Option) {
+	s.health.AddLivenessCheck(name, checker, opts...)
+}
+
+//AddReadinessCheck registers a check reported by /readyz
+func (s *HTTPServer) AddReadinessCheck(name string, checker health.Checker, opts ...health.Option) {
+	s.health.AddReadinessCheck(name, checker, opts...)
+}
+
+//Use appends middlewares that wrap every request served by the server,
+//the first middleware is the outermost one
+func (s *HTTPServer) Use(middlewares ...Middleware) {
+	s.middlewares = append(s.middlewares, middlewares...)
+}
+
+//Handler returns the server mux wrapped by the registered middlewares
+func (s *HTTPServer) Handler() http.Handler {
+	return clientCertificateHandler(chain(s.mux, s.middlewares...))
+}
+
+//OnShutdown registers a hook called after in-flight requests are drained,
+//e.g. to stop elasticsearch clients or flush loggers. Hooks run in registration order.
+func (s *HTTPServer) OnShutdown(hook func(context.Context) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdownHooks = append(s.shutdownHooks, hook)
+}
+
+//Addr returns the address of the main listener, empty before Start
+func (s *HTTPServer) Addr() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return ""
 	}
+	return s.listener.Addr().String()
+}
 
-	//gracefully shutdown
-	idleConnsClosed := make(chan struct{})
-	go func() {
-		sigint := make(chan os.Signal, 1)
-		signal.Notify(sigint, os.Interrupt)
-		<-sigint
+//Done returns a channel closed when the shutdown of the server completes
+func (s *HTTPServer) Done() <-chan struct{} {
+	return s.done
+}
 
-		logging.Infoln("http server Shutdown")
-		// We received an interrupt signal, shut down.
-		if err := server.Shutdown(context.Background()); err != nil {
-			// Error from closing listeners, or context timeout:
-			logging.Fatalln("http server Shutdown error")
+//Start listens and serves in the background, it returns once the listeners are bound.
+//The server shuts down gracefully when ctx is done.
+func (s *HTTPServer) Start(ctx context.Context) error {
+	var reloader *certReloader
+	if s.tlsConfig != nil {
+		var err error
+		if reloader, err = newCertReloader(s.tlsConfig); err != nil {
+			logging.Error("http server load tls certificates failed", logging.Fields{
+				"certFile": s.tlsConfig.CertFile,
+				"keyFile":  s.tlsConfig.KeyFile,
+				"caFile":   s.tlsConfig.ClientCAFile,
+			}, err)
+			return err
 		}
-		close(idleConnsClosed)
-	}()
+	}
 
-	logging.Info("http server started and served", logging.Fields{
-		"host": s.host,
-		"port": s.port,
-	})
-	if err := server.ListenAndServe(); err != http.ErrServerClosed {
-		// Error starting or closing listener:
-		logging.Fatal("HTTP server ListenAndServe", logging.Fields{
-			"error": err.Error(),
-		})
+	listener, err := s.listen()
+	if err != nil {
+		logging.Error("http server listen failed", logging.Fields{
+			"host":       s.host,
+			"port":       s.port,
+			"unixSocket": s.unixSocket,
+		}, err)
+		return err
+	}
+	if reloader != nil {
+		listener = tls.NewListener(listener, reloader.tlsConfig())
+	}
+	//h2c is only meaningful without TLS, clients negotiate HTTP/2 over TLS with ALPN
+	listeners := []net.Listener{listener}
+	h2c := []bool{s.h2c && reloader == nil}
+	for _, c := range s.extraListeners {
+		extra, err := c.listen()
+		if err != nil {
+			logging.Error("http server listen failed", logging.Fields{
+				"network": c.Network,
+				"addr":    c.Addr,
+			}, err)
+			closeListeners(listeners)
+			return err
+		}
+		listeners = append(listeners, extra)
+		h2c = append(h2c, c.H2C)
+	}
+	if s.admin != nil {
+		if err := s.startAdmin(); err != nil {
+			logging.Error("http admin server start failed", logging.Fields{
+				"host": s.admin.Host,
+				"port": s.admin.Port,
+			}, err)
+			closeListeners(listeners)
+			return err
+		}
 	}
 
-	<-idleConnsClosed
-}
+	//request contexts are cancelled when Shutdown stops waiting for them
+	serving, cancelServing := context.WithCancel(context.Background())
+	handler := s.Handler()
+	servers := make([]*http.Server, len(listeners))
+	for i := range listeners {
+		servers[i] = s.newServer(handler, h2c[i], serving)
+	}
+	s.mu.Lock()
+	s.listener = listener
+	s.listeners = listeners
+	s.servers = servers
+	s.startTime = time.Now()
+	s.cancelServing = cancelServing
+	s.mu.Unlock()
 
-// ----------------------------------------------------------------------------
-// logging
-// ---------------------------------------------------------------------------
-
-func loggingHandler(next ApiHandler) ApiHandlerFunc {
-	f := func(ctx context.Context, req *http.Request) (interface{}, int) {
-		start := time.Now()
-		logging.Info("request", logging.Fields{
-			"method": req.Method,
-			"url":    req.RequestURI,
-			"ip":     req.RemoteAddr,
-			"header": req.Header,
+	for i, l := range listeners {
+		logging.Info("http server started and served", logging.Fields{
+			"addr": l.Addr().String(),
+			"tls":  i == 0 && reloader != nil,
+			"h2c":  h2c[i],
 		})
-		res, code := next.Serve(ctx, req)
-		logging.Info("response", logging.Fields{
-			"response": res,
-			"code":     code,
-			"url":      req.RequestURI,
-			"duration": time.Since(start),
-		})
-
-		return res, code
+		go func(server *http.Server, l net.Listener) {
+			if err := server.Serve(l); err != http.ErrServerClosed {
+				// Error from the listener, shut down the rest of the server.
+				logging.Error("http server serve failed", logging.Fields{
+					"addr": l.Addr().String(),
+				}, err)
+				s.Shutdown(context.Background())
+			}
+		}(servers[i], l)
 	}
 
-	return ApiHandlerFunc(f)
+	//gracefully shutdown
+	go func() {
+		select {
+		case <-ctx.Done():
+			s.Shutdown(context.Background())
+		case <-s.done:
+		}
+	}()
+
+	return nil
 }
 
-// ----------------------------------------------------------------------------
-// HTTP headers
-// ---------------------------------------------------------------------------
+//Shutdown stops accepting connections, fails readiness, waits up to the drain timeout
+//for in-flight requests and then runs the shutdown hooks. Only the first call takes effect,
+//the following calls wait for it and return the same error.
+func (s *HTTPServer) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		defer close(s.done)
+		logging.Infoln("http server Shutdown")
+		s.health.SetShuttingDown()
+
+		if s.drainTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, s.drainTimeout)
+			defer cancel()
+		}
+
+		s.mu.Lock()
+		//no h2c request is added to h2cGroup once it is waited for
+		s.h2cClosed = true
+		servers := append([]*http.Server{}, s.servers...)
+		adminServer := s.adminServer
+		cancelServing := s.cancelServing
+		hooks := append([]func(context.Context) error{}, s.shutdownHooks...)
+		s.mu.Unlock()
+
+		//all listeners stop accepting at once and drain within the same timeout
+		errs := make(chan error, len(servers))
+		for _, server := range servers {
+			go func(server *http.Server) {
+				errs <- server.Shutdown(ctx)
+			}(server)
+		}
+		for range servers {
+			if err := <-errs; err != nil && s.shutdownErr == nil {
+				// Error from closing listeners, or context timeout:
+				logging.WithError("http server Shutdown error", err)
+				s.shutdownErr = err
+			}
+		}
+		//HTTP/2 cleartext connections are hijacked, their requests are not drained by the servers
+		if err := waitGroup(ctx, &s.h2cGroup); err != nil && s.shutdownErr == nil {
+			logging.WithError("http server drain h2c requests error", err)
+			s.shutdownErr = err
+		}
+		if err := s.closeWebSockets(ctx); err != nil && s.shutdownErr == nil {
+			logging.WithError("http server close websockets error", err)
+			s.shutdownErr = err
+		}
+		//abort the requests still running after the drain timeout, they get 503
+		if cancelServing != nil {
+			cancelServing()
+		}
+		hookCtx, cancelHooks := context.WithTimeout(context.Background(), s.shutdownTimeout)
+		defer cancelHooks()
+		for _, hook := range hooks {
+			if err := hook(hookCtx); err != nil {
+				logging.WithError("http server shutdown hook error", err)
+				if s.shutdownErr == nil {
+					s.shutdownErr = err
+				}
+			}
+		}
+		//the admin endpoints stay available for diagnostics while the server drains
+		if adminServer != nil {
+			if err := adminServer.Shutdown(ctx); err != nil && s.shutdownErr == nil {
+				logging.WithError("http admin server Shutdown error", err)
+				s.shutdownErr = err
+			}
+		}
+	})
 
-type httpHeaders struct {
-	headers map[string]string
+	<-s.done
+	return s.shutdownErr
 }
 
-func newHTTPHeaders() *httpHeaders {
-	return &httpHeaders{
-		headers: map[string]string{
-			"Content-Type": "application/json",
-		},
+//Run starts the server and blocks until it is shut down by SIGINT or SIGTERM
+func (s *HTTPServer) Run() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := s.Start(ctx); err != nil {
+		return
 	}
+	<-s.done
 }
 
-func SetHeader(ctx context.Context, key string, value string) {
-	if ctx.Value(HeaderKey) != nil {
-		header, _ := ctx.Value(HeaderKey).(*httpHeaders)
-		header.setHeader(key, value)
+func (s *HTTPServer) listen() (net.Listener, error) {
+	s.mu.Lock()
+	listener := s.listener
+	s.mu.Unlock()
+	if listener != nil {
+		return listener, nil
 	}
-}
 
-func (t *httpHeaders) getHeader() map[string]string {
-	if t.headers == nil {
-		tmp := make(map[string]string)
-		return tmp
+	if len(s.unixSocket) > 0 {
+		return listenUnix(s.unixSocket)
 	}
-	return t.headers
+
+	return net.Listen("tcp", net.JoinHostPort(s.host, strconv.Itoa(s.port)))
 }
 
-func (t *httpHeaders) setHeader(key string, value string) {
-	if t.headers == nil {
-		t.headers = make(map[string]string)
+//listenUnix listens on the socket at path, removing the socket file left by a previous
+//process. Other files are never removed, e.g. when path is misconfigured.
+func listenUnix(path string) (net.Listener, error) {
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && info.Mode()&os.ModeSocket == 0:
+		return nil, fmt.Errorf("%s exists and is not a unix socket", path)
+	case err == nil:
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	case !os.IsNotExist(err):
+		return nil, err
 	}
-	t.headers[key] = value
+	return net.Listen("unix", path)
 }
@@ -0,0 +1,142 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer_StartShutdown(t *testing.T) {
+	hookErr := errors.New("flush failed")
+	tests := []struct {
+		name    string
+		hooks   []error
+		wantErr error
+	}{
+		{
+			name:  "without hooks",
+			hooks: nil,
+		},
+		{
+			name:  "hooks succeed",
+			hooks: []error{nil, nil},
+		},
+		{
+			name:    "hook fails",
+			hooks:   []error{hookErr, nil},
+			wantErr: hookErr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewHTTPServer("127.0.0.1", 0, "/api", WithDrainTimeout(time.Second))
+			called := 0
+			for _, err := range tt.hooks {
+				err := err
+				s.OnShutdown(func(ctx context.Context) error {
+					if !s.health.IsShuttingDown() {
+						t.Errorf("readiness should fail before hooks run")
+					}
+					if ctx.Err() != nil {
+						t.Errorf("hook context error = %v, want its own timeout", ctx.Err())
+					}
+					called++
+					return err
+				})
+			}
+
+			if err := s.Start(context.Background()); err != nil {
+				t.Fatalf("Start() error = %v", err)
+			}
+			res, err := http.Get("http://" + s.Addr() + LivenessPath)
+			if err != nil {
+				t.Fatalf("GET %v error = %v", LivenessPath, err)
+			}
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Errorf("GET %v code = %v", LivenessPath, res.StatusCode)
+			}
+
+			if err := s.Shutdown(context.Background()); err != tt.wantErr {
+				t.Errorf("Shutdown() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if called != len(tt.hooks) {
+				t.Errorf("shutdown hooks called = %v, want %v", called, len(tt.hooks))
+			}
+			if err := s.Shutdown(context.Background()); err != tt.wantErr {
+				t.Errorf("second Shutdown() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHTTPServer_StartContextCancel(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewHTTPServer("", 0, "", WithListener(listener))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if s.Addr() != listener.Addr().String() {
+		t.Errorf("Addr() = %v, want %v", s.Addr(), listener.Addr())
+	}
+	cancel()
+
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("server is not shut down after context cancel")
+	}
+}
+
+func TestHTTPServer_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "orb.sock")
+	s := NewHTTPServer("", 0, "", WithUnixSocket(socket))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Shutdown(context.Background())
+
+	client := http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return net.Dial("unix", socket)
+			},
+		},
+	}
+	res, err := client.Get("http://unix" + ReadinessPath)
+	if err != nil {
+		t.Fatalf("GET %v error = %v", ReadinessPath, err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("GET %v code = %v", ReadinessPath, res.StatusCode)
+	}
+
+	//a misconfigured path never removes a regular file
+	file := filepath.Join(dir, "orb.conf")
+	if err := ioutil.WriteFile(file, []byte("conf"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewHTTPServer("", 0, "", WithUnixSocket(file)).Start(context.Background()); err == nil {
+		t.Errorf("Start() on a regular file error = nil")
+	}
+	if _, err := os.Stat(file); err != nil {
+		t.Errorf("stat %s error = %v, want the file kept", file, err)
+	}
+}
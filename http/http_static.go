@@ -0,0 +1,184 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	staticIndex           = "index.html"
+	immutableCacheControl = "public, max-age=31536000, immutable"
+)
+
+//defaultHashedAssets matches file names carrying a content hash, e.g. app.3f2a1b9c.js
+var defaultHashedAssets = regexp.MustCompile(`[.-][0-9a-f]{8,}\.[A-Za-z0-9]+$`)
+
+//WithSPA serves index.html for the paths of a static route that have no file and
+//no extension, so that the client side routes of a single-page app load the app
+func WithSPA() RouteOption {
+	return func(r *route) {
+		r.spa = true
+	}
+}
+
+//WithHashedAssets sets the pattern of the file names cached as immutable by a static route,
+//the other files are revalidated on every use
+func WithHashedAssets(pattern *regexp.Regexp) RouteOption {
+	return func(r *route) {
+		r.hashedAssets = pattern
+	}
+}
+
+//ServeDir serves the files of dir under urlPath, see ServeStatic
+func (s *HTTPServer) ServeDir(urlPath string, dir string, opts ...RouteOption) {
+	s.ServeStatic(urlPath, os.DirFS(dir), opts...)
+}
+
+//ServeStatic serves the files of root, e.g. an embed.FS narrowed by fs.Sub, under urlPath.
+//urlPath is not prefixed by the API prefix, requests under the prefix that match no API
+//route still get a JSON 404. Precompressed .br and .gz variants are served to the clients
+//accepting them.
+func (s *HTTPServer) ServeStatic(urlPath string, root fs.FS, opts ...RouteOption) {
+	if !strings.HasSuffix(urlPath, "/") {
+		urlPath += "/"
+	}
+	logging.Debug("mapping static files", logging.Fields{
+		"url": urlPath,
+	})
+
+	route := newRoute(urlPath, append([]RouteOption{WithHashedAssets(defaultHashedAssets)}, opts...))
+	route.pattern = urlPath
+	s.handle(route, "static", root, &staticHandler{
+		root:         root,
+		urlPath:      urlPath,
+		apiPrefix:    s.prefix,
+		spa:          route.spa,
+		hashedAssets: route.hashedAssets,
+	})
+}
+
+type staticHandler struct {
+	root         fs.FS
+	urlPath      string
+	apiPrefix    string
+	spa          bool
+	hashedAssets *regexp.Regexp
+	etags        sync.Map
+}
+
+func (h *staticHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if len(h.apiPrefix) > 0 && (r.URL.Path == h.apiPrefix || strings.HasPrefix(r.URL.Path, h.apiPrefix+"/")) {
+		WriteError(rw, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		rw.Header().Set("Allow", "GET, HEAD")
+		WriteError(rw, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+strings.TrimPrefix(r.URL.Path, h.urlPath)), "/")
+	if len(name) == 0 {
+		name = "."
+	}
+	if info, err := fs.Stat(h.root, name); err == nil && info.IsDir() {
+		name = path.Join(name, staticIndex)
+	}
+
+	if _, err := fs.Stat(h.root, name); err != nil {
+		if !h.spa || len(path.Ext(name)) > 0 {
+			WriteError(rw, http.StatusNotFound, "not found")
+			return
+		}
+		name = staticIndex
+	}
+	h.serveFile(rw, r, name)
+}
+
+func (h *staticHandler) serveFile(rw http.ResponseWriter, r *http.Request, name string) {
+	header := rw.Header()
+	header.Add("Vary", "Accept-Encoding")
+	if h.hashedAssets != nil && h.hashedAssets.MatchString(path.Base(name)) {
+		header.Set("Cache-Control", immutableCacheControl)
+	} else {
+		header.Set("Cache-Control", "no-cache")
+	}
+	if contentType := mime.TypeByExtension(path.Ext(name)); len(contentType) > 0 {
+		header.Set("Content-Type", contentType)
+	}
+
+	//prefer the precompressed variants, the content type stays the one of the original file
+	accept := r.Header.Get("Accept-Encoding")
+	for _, variant := range []struct{ encoding, ext string }{{"br", ".br"}, {"gzip", ".gz"}} {
+		if !strings.Contains(accept, variant.encoding) {
+			continue
+		}
+		if f, content, modTime, err := h.open(name + variant.ext); err == nil {
+			defer f.Close()
+			header.Set("Content-Encoding", variant.encoding)
+			h.serveContent(rw, r, name+variant.ext, modTime, content)
+			return
+		}
+	}
+
+	f, content, modTime, err := h.open(name)
+	if err != nil {
+		WriteError(rw, http.StatusNotFound, "not found")
+		return
+	}
+	defer f.Close()
+	h.serveContent(rw, r, name, modTime, content)
+}
+
+//serveContent uses a content based ETag for files without a modification time, e.g. embedded files
+func (h *staticHandler) serveContent(rw http.ResponseWriter, r *http.Request, name string, modTime time.Time,
+	content io.ReadSeeker) {
+	if modTime.IsZero() {
+		etag, ok := h.etags.Load(name)
+		if !ok {
+			sum := sha256.New()
+			io.Copy(sum, content)
+			content.Seek(0, io.SeekStart)
+			etag = `"` + hex.EncodeToString(sum.Sum(nil)[:16]) + `"`
+			h.etags.Store(name, etag)
+		}
+		rw.Header().Set("ETag", etag.(string))
+	}
+	http.ServeContent(rw, r, name, modTime, content)
+}
+
+//open returns the file and its content, the caller closes the file
+func (h *staticHandler) open(name string) (fs.File, io.ReadSeeker, time.Time, error) {
+	f, err := h.root.Open(name)
+	if err != nil {
+		return nil, nil, time.Time{}, err
+	}
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, nil, time.Time{}, fs.ErrNotExist
+	}
+	if content, ok := f.(io.ReadSeeker); ok {
+		return f, content, info.ModTime(), nil
+	}
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, time.Time{}, err
+	}
+	return f, bytes.NewReader(data), info.ModTime(), nil
+}
@@ -0,0 +1,130 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestHTTPServer_ServeStatic(t *testing.T) {
+	dist := fstest.MapFS{
+		"index.html":                {Data: []byte("<html>app</html>")},
+		"assets/app.3f2a1b9c.js":    {Data: []byte("console.log('app')")},
+		"assets/app.3f2a1b9c.js.br": {Data: []byte("brotli")},
+		"assets/app.3f2a1b9c.js.gz": {Data: []byte("gzip")},
+		"assets/logo.svg":           {Data: []byte("<svg/>")},
+		"docs/index.html":           {Data: []byte("<html>docs</html>")},
+	}
+	s := NewHTTPServer("localhost", 0, "/api", WithoutAccessLog())
+	s.RegisterApiHandler("/alerts", ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		return []string{}, 0
+	}))
+	s.ServeStatic("/", dist, WithSPA())
+
+	tests := []struct {
+		name           string
+		method         string
+		url            string
+		acceptEncoding string
+		status         int
+		body           string
+		contentType    string
+		cacheControl   string
+		encoding       string
+	}{
+		{"root serves index", "GET", "/", "", 200, "<html>app</html>", "text/html; charset=utf-8", "no-cache", ""},
+		{"hashed asset is immutable", "GET", "/assets/app.3f2a1b9c.js", "", 200, "console.log('app')", "text/javascript; charset=utf-8", immutableCacheControl, ""},
+		{"brotli variant", "GET", "/assets/app.3f2a1b9c.js", "gzip, br", 200, "brotli", "text/javascript; charset=utf-8", immutableCacheControl, "br"},
+		{"gzip variant", "GET", "/assets/app.3f2a1b9c.js", "gzip", 200, "gzip", "text/javascript; charset=utf-8", immutableCacheControl, "gzip"},
+		{"asset without hash", "GET", "/assets/logo.svg", "gzip", 200, "<svg/>", "image/svg+xml", "no-cache", ""},
+		{"directory index", "GET", "/docs/", "", 200, "<html>docs</html>", "text/html; charset=utf-8", "no-cache", ""},
+		{"spa fallback", "GET", "/dashboard/alerts", "", 200, "<html>app</html>", "text/html; charset=utf-8", "no-cache", ""},
+		{"missing asset", "GET", "/assets/missing.js", "", 404, `{"code":404,"message":"not found"}` + "\n", "application/json", "", ""},
+		{"api route", "GET", "/api/alerts", "", 200, "[]\n", "application/json", "", ""},
+		{"unknown api route", "GET", "/api/unknown", "", 404, `{"code":404,"message":"not found"}` + "\n", "application/json", "", ""},
+		{"method not allowed", "POST", "/", "", 405, "", "application/json", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if len(tt.acceptEncoding) > 0 {
+				req.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rw := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rw, req)
+
+			if rw.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body %s", rw.Code, tt.status, rw.Body.String())
+			}
+			if len(tt.body) > 0 && rw.Body.String() != tt.body {
+				t.Errorf("body = %q, want %q", rw.Body.String(), tt.body)
+			}
+			if got := rw.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if got := rw.Header().Get("Cache-Control"); got != tt.cacheControl {
+				t.Errorf("Cache-Control = %q, want %q", got, tt.cacheControl)
+			}
+			if got := rw.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Errorf("Content-Encoding = %q, want %q", got, tt.encoding)
+			}
+		})
+	}
+
+	rw := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/", nil))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("If-None-Match", rw.Header().Get("ETag"))
+	rw = httptest.NewRecorder()
+	s.Handler().ServeHTTP(rw, req)
+	if rw.Code != http.StatusNotModified {
+		t.Errorf("conditional request status = %d, want 304", rw.Code)
+	}
+}
+
+func TestHTTPServer_ServeDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orb-static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.html"), []byte("home"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewHTTPServer("localhost", 0, "", WithoutAccessLog())
+	s.ServeDir("/ui", dir)
+
+	tests := []struct {
+		url    string
+		status int
+	}{
+		{"/ui/", 200},
+		{"/ui/index.html", 200},
+		//traversals are redirected or rejected depending on the Go version, never served
+		{"/ui/../../etc/passwd", 0},
+		{"/ui/dashboard", 404},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, tt.url, nil))
+		if tt.status == 0 {
+			if rw.Code == http.StatusOK || strings.Contains(rw.Body.String(), "root:") {
+				t.Errorf("GET %s status = %d, body %q, want the file not served", tt.url, rw.Code, rw.Body.String())
+			}
+			continue
+		}
+		if rw.Code != tt.status {
+			t.Errorf("GET %s status = %d, want %d", tt.url, rw.Code, tt.status)
+		}
+		if tt.status == 200 && rw.Header().Get("Last-Modified") == "" {
+			t.Errorf("GET %s has no Last-Modified", tt.url)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	//LastEventIDHeader is sent by EventSource clients when they reconnect
+	LastEventIDHeader = "Last-Event-ID"
+
+	defaultHeartbeat = 15 * time.Second
+)
+
+var (
+	//ErrStreamClosed is returned by Stream once the client has gone away
+	ErrStreamClosed = errors.New("stream closed")
+	//ErrInvalidEvent is returned by SendEvent when the ID or Event contain line breaks,
+	//which would inject fields or events into the Server-Sent Events stream
+	ErrInvalidEvent = errors.New("event id and name must not contain line breaks")
+)
+
+//Event is a message of a stream, ID, Event and Retry are only used by Server-Sent Events
+type Event struct {
+	ID    string
+	Event string
+	Data  interface{}
+	Retry time.Duration
+}
+
+//Stream writes messages to the client as Server-Sent Events when the client
+//accepts text/event-stream, or as newline delimited JSON otherwise
+type Stream interface {
+	//Send writes v as a single message
+	Send(v interface{}) error
+	//SendEvent writes an event with its metadata
+	SendEvent(event Event) error
+	//Flush sends the buffered messages, only needed with WithBufferedStream
+	Flush() error
+	//LastEventID returns the id of the last event received by a reconnecting client
+	LastEventID() string
+}
+
+//StreamHandler serves a streaming response, ctx is cancelled when the client disconnects
+type StreamHandler interface {
+	ServeStream(ctx context.Context, req *http.Request, stream Stream) error
+}
+
+//StreamHandlerFunc is an adapter to use ordinary functions as StreamHandler
+type StreamHandlerFunc func(context.Context, *http.Request, Stream) error
+
+//ServeStream implement StreamHandler interface
+func (f StreamHandlerFunc) ServeStream(ctx context.Context, req *http.Request, stream Stream) error {
+	return f(ctx, req, stream)
+}
+
+//WithHeartbeat set the interval of keep-alive messages of a stream route, 0 disables them
+func WithHeartbeat(interval time.Duration) RouteOption {
+	return func(r *route) {
+		r.heartbeat = interval
+	}
+}
+
+//WithBufferedStream stops flushing after every message of a stream route,
+//the handler controls flushing by calling Stream.Flush
+func WithBufferedStream() RouteOption {
+	return func(r *route) {
+		r.bufferedStream = true
+	}
+}
+
+//RegisterStreamHandler registers a streaming handler for url
+func (s *HTTPServer) RegisterStreamHandler(url string, handler StreamHandler, opts ...RouteOption) {
+	if len(url) == 0 {
+		logging.Errorln("register url is invalid")
+	}
+
+	logging.Debug("mapping stream handler", logging.Fields{
+		"prefix":  s.prefix,
+		"url":     url,
+		"handler": reflect.TypeOf(handler),
+	})
+	route := newRoute(url, append([]RouteOption{WithHeartbeat(defaultHeartbeat)}, opts...))
+	s.handle(route, "stream", handler, &streamHandler{
+		handler:   handler,
+		heartbeat: route.heartbeat,
+		autoFlush: !route.bufferedStream,
+	})
+}
+
+type streamHandler struct {
+	handler   StreamHandler
+	heartbeat time.Duration
+	autoFlush bool
+}
+
+func (h *streamHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	flusher, ok := rw.(http.Flusher)
+	if !ok {
+		WriteError(rw, http.StatusInternalServerError, "streaming unsupported")
+		return
+	}
+	//streams outlive the write timeout of the server
+	http.NewResponseController(rw).SetWriteDeadline(time.Time{})
+
+	st := &stream{
+		rw:          rw,
+		flusher:     flusher,
+		sse:         strings.Contains(r.Header.Get("Accept"), "text/event-stream"),
+		autoFlush:   h.autoFlush,
+		lastEventID: r.Header.Get(LastEventIDHeader),
+	}
+	if len(st.lastEventID) == 0 {
+		st.lastEventID = r.URL.Query().Get("lastEventId")
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	var wg sync.WaitGroup
+	if h.heartbeat > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			st.keepalive(ctx, h.heartbeat)
+		}()
+	}
+
+	start := time.Now()
+	err := h.handler.ServeStream(ctx, r, st)
+	//the response must not be written once ServeHTTP returns
+	cancel()
+	wg.Wait()
+	if err != nil && err != context.Canceled && err != ErrStreamClosed {
+		logging.Error("stream failed", logging.Fields{
+			"url":    r.RequestURI,
+			"events": st.count,
+		}, err)
+		st.fail(err)
+	}
+
+	logging.Info("stream closed", logging.Fields{
+		"url":      r.RequestURI,
+		"events":   st.count,
+		"duration": time.Since(start),
+	})
+}
+
+type stream struct {
+	sync.Mutex
+	rw          http.ResponseWriter
+	flusher     http.Flusher
+	sse         bool
+	autoFlush   bool
+	lastEventID string
+	started     bool
+	closed      bool
+	count       int
+}
+
+func (s *stream) Send(v interface{}) error {
+	return s.SendEvent(Event{Data: v})
+}
+
+func (s *stream) SendEvent(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n") || strings.ContainsAny(event.Event, "\r\n") {
+		return ErrInvalidEvent
+	}
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(event.Data); err != nil {
+		return err
+	}
+	data := bytes.TrimRight(buf.Bytes(), "\n")
+
+	var msg bytes.Buffer
+	if s.sse {
+		if len(event.ID) > 0 {
+			msg.WriteString("id: " + event.ID + "\n")
+		}
+		if len(event.Event) > 0 {
+			msg.WriteString("event: " + event.Event + "\n")
+		}
+		if event.Retry > 0 {
+			msg.WriteString("retry: " + strconv.FormatInt(int64(event.Retry/time.Millisecond), 10) + "\n")
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			msg.WriteString("data: ")
+			msg.Write(line)
+			msg.WriteString("\n")
+		}
+		msg.WriteString("\n")
+	} else {
+		msg.Write(data)
+		msg.WriteString("\n")
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	s.count++
+	return s.write(msg.Bytes(), s.autoFlush)
+}
+
+func (s *stream) Flush() error {
+	s.Lock()
+	defer s.Unlock()
+	return s.write(nil, true)
+}
+
+func (s *stream) LastEventID() string {
+	return s.lastEventID
+}
+
+//keepalive writes a comment for SSE, or an empty line for NDJSON, when the stream is idle
+func (s *stream) keepalive(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	heartbeat := []byte("\n")
+	if s.sse {
+		heartbeat = []byte(": heartbeat\n\n")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Lock()
+			err := s.write(heartbeat, true)
+			s.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+//fail reports err to the client, as an error status if nothing has been written yet
+func (s *stream) fail(err error) {
+	s.Lock()
+	defer s.Unlock()
+	if !s.started {
+		s.started = true
+		WriteError(s.rw, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	data, _ := json.Marshal(ErrorResponse{
+		Code:    http.StatusInternalServerError,
+		Message: err.Error(),
+	})
+	if s.sse {
+		data = append(append([]byte("event: error\ndata: "), data...), '\n')
+	}
+	s.write(append(data, '\n'), true)
+}
+
+//write must be called with the lock held
+func (s *stream) write(b []byte, flush bool) error {
+	if s.closed {
+		return ErrStreamClosed
+	}
+	if !s.started {
+		s.started = true
+		header := s.rw.Header()
+		if s.sse {
+			header.Set("Content-Type", "text/event-stream")
+		} else {
+			header.Set("Content-Type", "application/x-ndjson")
+		}
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no")
+		s.rw.WriteHeader(http.StatusOK)
+	}
+	if len(b) > 0 {
+		if _, err := s.rw.Write(b); err != nil {
+			s.closed = true
+			return ErrStreamClosed
+		}
+	}
+	if flush {
+		s.flusher.Flush()
+	}
+	return nil
+}
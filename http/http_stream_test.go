@@ -0,0 +1,154 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+type tailMessage struct {
+	ChatID  string `json:"chatid"`
+	Content string `json:"content"`
+}
+
+func TestStreamHandler(t *testing.T) {
+	messages := []tailMessage{
+		{ChatID: "ops", Content: "disk full"},
+		{ChatID: "ops", Content: "disk ok"},
+		{ChatID: "dev", Content: "build <failed>"},
+	}
+
+	s := NewHTTPServer("localhost", 0, "/api")
+	s.RegisterStreamHandler("/tail", StreamHandlerFunc(func(ctx context.Context, req *http.Request, stream Stream) error {
+		if name := req.URL.Query().Get("event"); len(name) > 0 {
+			return stream.SendEvent(Event{Event: name, Data: messages[0]})
+		}
+		start := 0
+		if id := stream.LastEventID(); len(id) > 0 {
+			start, _ = strconv.Atoi(id)
+		}
+		for i := start; i < len(messages); i++ {
+			if err := stream.SendEvent(Event{ID: strconv.Itoa(i + 1), Event: "message", Data: messages[i]}); err != nil {
+				return err
+			}
+		}
+		if req.URL.Query().Get("fail") == "true" {
+			return errors.New("wechat unavailable")
+		}
+		return nil
+	}), WithHeartbeat(0))
+
+	tests := []struct {
+		name        string
+		url         string
+		accept      string
+		lastEventID string
+		wantType    string
+		wantBody    string
+	}{
+		{
+			name:     "ndjson",
+			url:      "/api/tail",
+			wantType: "application/x-ndjson",
+			wantBody: `{"chatid":"ops","content":"disk full"}` + "\n" +
+				`{"chatid":"ops","content":"disk ok"}` + "\n" +
+				`{"chatid":"dev","content":"build <failed>"}` + "\n",
+		},
+		{
+			name:        "server-sent events resumed by Last-Event-ID",
+			url:         "/api/tail",
+			accept:      "text/event-stream",
+			lastEventID: "2",
+			wantType:    "text/event-stream",
+			wantBody:    "id: 3\nevent: message\ndata: {\"chatid\":\"dev\",\"content\":\"build <failed>\"}\n\n",
+		},
+		{
+			name:     "error after events",
+			url:      "/api/tail?fail=true&lastEventId=2",
+			accept:   "text/event-stream",
+			wantType: "text/event-stream",
+			wantBody: "id: 3\nevent: message\ndata: {\"chatid\":\"dev\",\"content\":\"build <failed>\"}\n\n" +
+				"event: error\ndata: {\"code\":500,\"message\":\"wechat unavailable\"}\n\n",
+		},
+		{
+			name:     "error before events",
+			url:      "/api/tail?fail=true&lastEventId=3",
+			wantType: "application/json",
+			wantBody: "{\"code\":500,\"message\":\"wechat unavailable\"}\n",
+		},
+		{
+			name:     "event name injecting an event",
+			url:      "/api/tail?event=" + url.QueryEscape("message\n\nevent: admin"),
+			accept:   "text/event-stream",
+			wantType: "application/json",
+			wantBody: "{\"code\":500,\"message\":\"" + ErrInvalidEvent.Error() + "\"}\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header.Set("Accept", tt.accept)
+			req.Header.Set(LastEventIDHeader, tt.lastEventID)
+			rw := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rw, req)
+
+			if got := rw.Header().Get("Content-Type"); got != tt.wantType {
+				t.Errorf("Content-Type = %v, want %v", got, tt.wantType)
+			}
+			if rw.Body.String() != tt.wantBody {
+				t.Errorf("body = %q, want %q", rw.Body.String(), tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestStreamHandler_HeartbeatAndDisconnect(t *testing.T) {
+	done := make(chan error, 1)
+	s := NewHTTPServer("127.0.0.1", 0, "")
+	s.RegisterStreamHandler("/live", StreamHandlerFunc(func(ctx context.Context, req *http.Request, stream Stream) error {
+		stream.Send("hello")
+		<-ctx.Done()
+		done <- ctx.Err()
+		return ctx.Err()
+	}), WithHeartbeat(10*time.Millisecond))
+
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/live", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(res.Body)
+	var lines []string
+	for len(lines) < 4 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, strings.TrimSpace(line))
+	}
+	if lines[0] != `data: "hello"` || lines[2] != ": heartbeat" {
+		t.Errorf("stream lines = %q", lines)
+	}
+	res.Body.Close()
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("handler context error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("handler context is not cancelled after client disconnect")
+	}
+}
@@ -0,0 +1,88 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+//WithTimeout sets a deadline on the context of the route handler, it flows into the
+//outbound calls made with the context. Api handlers still running at the deadline get
+//504, and 503 when the server stops waiting for them during shutdown.
+func WithTimeout(timeout time.Duration) RouteOption {
+	return func(r *route) {
+		r.timeout = timeout
+	}
+}
+
+//deadlineHandler only sets the deadline, for streams and websockets which own their response
+func deadlineHandler(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		next.ServeHTTP(rw, r.WithContext(ctx))
+	})
+}
+
+//timeoutHandler answers when the deadline expires even if the handler ignores its context,
+//the response of the handler is buffered and discarded if it completes too late
+func timeoutHandler(timeout time.Duration, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		w := newBufferedWriter()
+		done := make(chan struct{})
+		panicked := make(chan interface{})
+		answered := make(chan struct{})
+		defer close(answered)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					select {
+					case panicked <- p:
+					case <-answered:
+						//the client already got the timeout, nothing else would report the panic
+						logging.Error("handler panicked after the deadline", logging.Fields{
+							"url":   r.RequestURI,
+							"stack": string(debug.Stack()),
+						}, fmt.Errorf("%v", p))
+					}
+				}
+			}()
+			next.ServeHTTP(w, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			if err := ctx.Err(); err != nil {
+				writeContextError(rw, r, err)
+				return
+			}
+			w.response().writeTo(rw)
+		case <-ctx.Done():
+			writeContextError(rw, r, ctx.Err())
+		}
+	})
+}
+
+//writeContextError answers a request whose context ended before the handler completed
+func writeContextError(rw http.ResponseWriter, r *http.Request, err error) {
+	if err == context.DeadlineExceeded {
+		logging.Warn("request deadline exceeded", logging.Fields{
+			"url": r.RequestURI,
+		})
+		WriteError(rw, http.StatusGatewayTimeout, "request deadline exceeded")
+		return
+	}
+	//the client has gone away or the server is shutting down
+	WriteError(rw, http.StatusServiceUnavailable, fmt.Sprintf("request cancelled: %v", err))
+}
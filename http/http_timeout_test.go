@@ -0,0 +1,125 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type testContextKey string
+
+func TestWithTimeout(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}))
+	defer slow.Close()
+
+	release := make(chan struct{})
+	defer close(release)
+
+	tests := []struct {
+		name    string
+		handler ApiHandlerFunc
+		status  int
+	}{
+		{
+			name: "completes in time",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				SetHeader(ctx, "X-Caller", ctx.Value(testContextKey("caller")).(string))
+				if _, ok := ctx.Deadline(); !ok {
+					t.Errorf("handler context has no deadline")
+				}
+				return "ok", 0
+			},
+			status: http.StatusOK,
+		},
+		{
+			name: "honours the context",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				<-ctx.Done()
+				return nil, 0
+			},
+			status: http.StatusGatewayTimeout,
+		},
+		{
+			name: "ignores the context",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				<-release
+				return "late", 0
+			},
+			status: http.StatusGatewayTimeout,
+		},
+		{
+			name: "outbound call is cancelled",
+			handler: func(ctx context.Context, req *http.Request) (interface{}, int) {
+				if _, err := GetWithContext(ctx, slow.URL, nil); err == nil {
+					t.Errorf("outbound call error = nil, want deadline exceeded")
+				}
+				return nil, 0
+			},
+			status: http.StatusGatewayTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewHTTPServer("localhost", 0, "", WithoutAccessLog())
+			s.Use(func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+					next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), testContextKey("caller"), "ops")))
+				})
+			})
+			s.RegisterApiHandler("/alerts", tt.handler, WithTimeout(50*time.Millisecond))
+
+			start := time.Now()
+			rw := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/alerts", nil))
+			if rw.Code != tt.status {
+				t.Errorf("status = %d, want %d, body %s", rw.Code, tt.status, rw.Body.String())
+			}
+			if tt.status == http.StatusOK && rw.Header().Get("X-Caller") != "ops" {
+				t.Errorf("X-Caller = %q, want the value of the request context", rw.Header().Get("X-Caller"))
+			}
+			if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+				t.Errorf("request took %v, want it to end at the deadline", elapsed)
+			}
+		})
+	}
+}
+
+func TestHTTPServer_ShutdownCancelsRequests(t *testing.T) {
+	s := NewHTTPServer("127.0.0.1", 0, "", WithDrainTimeout(50*time.Millisecond), WithoutAccessLog())
+	started := make(chan struct{})
+	s.RegisterApiHandler("/wait", ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		close(started)
+		<-ctx.Done()
+		return nil, 0
+	}))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	result := make(chan int, 1)
+	go func() {
+		res, err := http.Get("http://" + s.Addr() + "/wait")
+		if err != nil {
+			t.Errorf("GET /wait error = %v", err)
+			result <- 0
+			return
+		}
+		res.Body.Close()
+		result <- res.StatusCode
+	}()
+	<-started
+
+	if err := s.Shutdown(context.Background()); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if status := <-result; status != http.StatusServiceUnavailable {
+		t.Errorf("in-flight request status = %d, want 503", status)
+	}
+}
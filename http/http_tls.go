@@ -0,0 +1,211 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	//ClientCertificateKey context key of the verified client certificate
+	ClientCertificateKey = contextKey("clientCertificate")
+
+	defaultReloadInterval = 10 * time.Second
+)
+
+//TLSConfig is the TLS configuration of HTTPServer
+type TLSConfig struct {
+	//CertFile and KeyFile are the PEM encoded server certificate chain and private key
+	CertFile string
+	KeyFile  string
+	//MinVersion defaults to TLS 1.2
+	MinVersion uint16
+	//CipherSuites is only used by TLS 1.2 and below, nil uses the go defaults
+	CipherSuites []uint16
+	//ClientCAFile is a PEM bundle of the CAs trusted to sign client certificates,
+	//client certificates are required and verified when it is set
+	ClientCAFile string
+	//ReloadInterval is the minimum interval between checks of the files on disk,
+	//negative disables reloading
+	ReloadInterval time.Duration
+}
+
+//WithTLS serves HTTPS with the certificates of config
+func WithTLS(config *TLSConfig) ServerOption {
+	return func(s *HTTPServer) {
+		s.tlsConfig = config
+	}
+}
+
+//ClientCertificate returns the verified client certificate of a mutual TLS request
+func ClientCertificate(ctx context.Context) *x509.Certificate {
+	if ctx == nil {
+		return nil
+	}
+	cert, _ := ctx.Value(ClientCertificateKey).(*x509.Certificate)
+	return cert
+}
+
+//clientCertificateHandler places the leaf client certificate into the request context
+func clientCertificateHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+			cert := r.TLS.VerifiedChains[0][0]
+			r = r.WithContext(context.WithValue(r.Context(), ClientCertificateKey, cert))
+		}
+		next.ServeHTTP(rw, r)
+	})
+}
+
+//certReloader keeps the certificates in sync with the files on disk
+type certReloader struct {
+	sync.Mutex
+	config     *TLSConfig
+	interval   time.Duration
+	lastCheck  time.Time
+	modTimes   map[string]time.Time
+	cert       *tls.Certificate
+	clientCAs  *x509.CertPool
+	baseConfig *tls.Config
+}
+
+func newCertReloader(config *TLSConfig) (*certReloader, error) {
+	if len(config.CertFile) == 0 || len(config.KeyFile) == 0 {
+		return nil, errors.New("tls cert file and key file are required")
+	}
+
+	r := &certReloader{
+		config:   config,
+		interval: config.ReloadInterval,
+		modTimes: make(map[string]time.Time),
+	}
+	if r.interval == 0 {
+		r.interval = defaultReloadInterval
+	}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	minVersion := config.MinVersion
+	if minVersion == 0 {
+		minVersion = tls.VersionTLS12
+	}
+	r.baseConfig = &tls.Config{
+		MinVersion:   minVersion,
+		CipherSuites: config.CipherSuites,
+	}
+	if len(config.ClientCAFile) > 0 {
+		r.baseConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return r, nil
+}
+
+func (r *certReloader) files() []string {
+	files := []string{r.config.CertFile, r.config.KeyFile}
+	if len(r.config.ClientCAFile) > 0 {
+		files = append(files, r.config.ClientCAFile)
+	}
+	return files
+}
+
+func (r *certReloader) load() error {
+	modTimes := make(map[string]time.Time)
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.config.CertFile, r.config.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	var clientCAs *x509.CertPool
+	if len(r.config.ClientCAFile) > 0 {
+		pem, err := ioutil.ReadFile(r.config.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificate found in %s", r.config.ClientCAFile)
+		}
+	}
+
+	r.cert = &cert
+	r.clientCAs = clientCAs
+	r.modTimes = modTimes
+	return nil
+}
+
+//maybeReload reloads the files when one of them changed, the previous
+//certificates are kept if the new files are invalid
+func (r *certReloader) maybeReload() {
+	r.Lock()
+	defer r.Unlock()
+
+	if r.interval < 0 || time.Since(r.lastCheck) < r.interval {
+		return
+	}
+	r.lastCheck = time.Now()
+
+	changed := false
+	for _, file := range r.files() {
+		info, err := os.Stat(file)
+		if err != nil {
+			logging.Error("stat tls file failed", logging.Fields{
+				"file": file,
+			}, err)
+			return
+		}
+		if !info.ModTime().Equal(r.modTimes[file]) {
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+
+	if err := r.load(); err != nil {
+		logging.Error("reload tls certificates failed", logging.Fields{
+			"certFile": r.config.CertFile,
+			"keyFile":  r.config.KeyFile,
+			"caFile":   r.config.ClientCAFile,
+		}, err)
+		return
+	}
+	logging.Info("tls certificates reloaded", logging.Fields{
+		"certFile": r.config.CertFile,
+	})
+}
+
+//tlsConfig returns the tls.Config of the server, the certificates are
+//resolved on every handshake so that reloads take effect immediately
+func (r *certReloader) tlsConfig() *tls.Config {
+	config := r.baseConfig.Clone()
+	config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.maybeReload()
+
+		r.Lock()
+		defer r.Unlock()
+		c := r.baseConfig.Clone()
+		c.Certificates = []tls.Certificate{*r.cert}
+		c.ClientCAs = r.clientCAs
+		c.NextProtos = []string{"h2", "http/1.1"}
+		return c, nil
+	}
+	return config
+}
@@ -0,0 +1,163 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+func newTestCert(t *testing.T, cn string, serial int64, parent *testCert, isCA bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(serial),
+		Subject:               pkix.Name{CommonName: cn},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		DNSNames:              []string{cn},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tlsCertificate(t *testing.T) tls.Certificate {
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(t *testing.T, path string, data []byte, modTime time.Time) {
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPServer_MutualTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "orb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := newTestCert(t, "orb-ca", 1, nil, true)
+	server := newTestCert(t, "127.0.0.1", 2, ca, false)
+	client := newTestCert(t, "orb-client", 3, ca, false)
+	config := &TLSConfig{
+		CertFile:       filepath.Join(dir, "server.crt"),
+		KeyFile:        filepath.Join(dir, "server.key"),
+		ClientCAFile:   filepath.Join(dir, "ca.crt"),
+		ReloadInterval: time.Millisecond,
+	}
+	modTime := time.Now().Add(-time.Minute)
+	writeFile(t, config.CertFile, server.certPEM, modTime)
+	writeFile(t, config.KeyFile, server.keyPEM, modTime)
+	writeFile(t, config.ClientCAFile, ca.certPEM, modTime)
+
+	s := NewHTTPServer("127.0.0.1", 0, "/api", WithTLS(config))
+	s.RegisterApiHandler("/whoami", ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		return ClientCertificate(req.Context()).Subject.CommonName, 0
+	}))
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Shutdown(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs},
+			},
+		}
+	}
+
+	res, err := newClient(client.tlsCertificate(t)).Get("https://" + s.Addr() + "/api/whoami")
+	if err != nil {
+		t.Fatalf("GET with client certificate error = %v", err)
+	}
+	body, _ := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if string(body) != "\"orb-client\"\n" {
+		t.Errorf("client identity = %s, want orb-client", body)
+	}
+	if res.TLS.PeerCertificates[0].SerialNumber.Int64() != 2 {
+		t.Errorf("server certificate serial = %v, want 2", res.TLS.PeerCertificates[0].SerialNumber)
+	}
+
+	if _, err := newClient().Get("https://" + s.Addr() + "/api/whoami"); err == nil {
+		t.Errorf("GET without client certificate should fail")
+	}
+
+	//replace the server certificate on disk
+	renewed := newTestCert(t, "127.0.0.1", 4, ca, false)
+	writeFile(t, config.CertFile, renewed.certPEM, time.Now())
+	writeFile(t, config.KeyFile, renewed.keyPEM, time.Now())
+	time.Sleep(10 * time.Millisecond)
+
+	res, err = newClient(client.tlsCertificate(t)).Get("https://" + s.Addr() + "/api/whoami")
+	if err != nil {
+		t.Fatalf("GET after reload error = %v", err)
+	}
+	res.Body.Close()
+	if res.TLS.PeerCertificates[0].SerialNumber.Int64() != 4 {
+		t.Errorf("reloaded certificate serial = %v, want 4", res.TLS.PeerCertificates[0].SerialNumber)
+	}
+}
+
+func TestHTTPServer_TLSInvalidFiles(t *testing.T) {
+	s := NewHTTPServer("127.0.0.1", 0, "", WithTLS(&TLSConfig{
+		CertFile: "not-exist.crt",
+		KeyFile:  "not-exist.key",
+	}))
+	if err := s.Start(context.Background()); err == nil {
+		t.Errorf("Start() with missing certificates should fail")
+	}
+}
@@ -0,0 +1,27 @@
+package http
+
+import (
+	"net/http"
+
+	"github.com/v-zhidu/orb/trace"
+)
+
+//Tracing is a server middleware that continues the trace of the incoming
+//traceparent header, or starts a new trace when the header is absent
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		ctx := trace.Extract(r.Context(), r.Header)
+		ctx, span := trace.Start(ctx, r.Method+" "+r.URL.Path, trace.SpanKindServer)
+		defer span.End()
+
+		span.SetAttribute("http.method", r.Method)
+		span.SetAttribute("http.target", r.URL.RequestURI())
+		span.SetAttribute("http.host", r.Host)
+		span.SetAttribute("net.peer.addr", r.RemoteAddr)
+
+		rec := newResponseRecorder(rw)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttribute("http.status_code", rec.status)
+	})
+}
@@ -0,0 +1,64 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/v-zhidu/orb/trace"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := trace.NewInMemoryExporter()
+	trace.SetExporter(exporter)
+	defer trace.SetExporter(nil)
+
+	var upstreamParent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Get(trace.TraceparentHeader)
+		rw.Write([]byte("{}"))
+	}))
+	defer upstream.Close()
+
+	s := NewHTTPServer("localhost", 0, "/api")
+	s.Use(Tracing)
+	s.RegisterApiHandler("/trace", ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+		if _, err := GetWithContext(ctx, upstream.URL+"/cgi-bin/gettoken?corpsecret=s3cr3t", nil); err != nil {
+			t.Errorf("GetWithContext() error = %v", err)
+		}
+		return nil, 0
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/trace", nil)
+	req.Header.Set(trace.TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rw := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rw, req)
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("exported spans = %v, want 2", len(spans))
+	}
+	client, server := spans[0], spans[1]
+	if server.Kind != trace.SpanKindServer || client.Kind != trace.SpanKindClient {
+		t.Errorf("span kinds = %v, %v", server.Kind, client.Kind)
+	}
+	if server.SpanContext.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("server trace id = %v", server.SpanContext.TraceID)
+	}
+	if server.ParentSpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("server parent span id = %v", server.ParentSpanID)
+	}
+	if client.ParentSpanID != server.SpanContext.SpanID {
+		t.Errorf("client parent span id = %v, want %v", client.ParentSpanID, server.SpanContext.SpanID)
+	}
+	if upstreamParent != trace.FormatTraceparent(client.SpanContext) {
+		t.Errorf("upstream traceparent = %v, want %v", upstreamParent, trace.FormatTraceparent(client.SpanContext))
+	}
+	if url := client.Attributes["http.url"]; url != upstream.URL+"/cgi-bin/gettoken" {
+		t.Errorf("client url = %v, want it without the query", url)
+	}
+	if server.Attributes["http.status_code"] != http.StatusOK {
+		t.Errorf("server status code = %v", server.Attributes["http.status_code"])
+	}
+}
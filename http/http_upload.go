@@ -0,0 +1,399 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultMaxFileSize  = 32 << 20
+	defaultMaxTotalSize = 100 << 20
+	defaultMaxFiles     = 16
+	maxFieldSize        = 1 << 20
+	sniffLen            = 512
+)
+
+//UploadError is returned by ParseUpload for an upload the client has to change, an
+//ApiHandler returning it answers with an ErrorResponse of Status
+type UploadError struct {
+	Status  int
+	Message string
+}
+
+func (e *UploadError) Error() string {
+	return e.Message
+}
+
+//StatusCode implement StatusError interface
+func (e *UploadError) StatusCode() int {
+	return e.Status
+}
+
+//ErrNotMultipart is returned by ParseUpload for requests that are not multipart/form-data
+var ErrNotMultipart = &UploadError{Status: http.StatusBadRequest, Message: "request is not multipart/form-data"}
+
+//UploadConfig limits the uploads accepted by ParseUpload
+type UploadConfig struct {
+	//MaxFileSize is the limit of each file, 32MB by default
+	MaxFileSize int64
+	//MaxTotalSize is the limit of all files and fields, 100MB by default
+	MaxTotalSize int64
+	//MaxFiles is the limit of files, 16 by default
+	MaxFiles int
+	//AllowedTypes are the accepted sniffed media types, e.g. image/png or image/*, all by default
+	AllowedTypes []string
+	//TempDir is where the files are stored, the system temp dir by default
+	TempDir string
+	//OnFile receives the content of each file instead of storing it in a temp file
+	OnFile func(ctx context.Context, file *UploadedFile, content io.Reader) error
+}
+
+//UploadedFile is a file of a multipart upload
+type UploadedFile struct {
+	Field    string
+	Filename string
+	//ContentType is sniffed from the content, the type declared by the client is not trusted
+	ContentType string
+	Size        int64
+	//Path is the temp file holding the content, empty when OnFile consumed it
+	Path string
+}
+
+//Open opens the temp file of the upload
+func (f *UploadedFile) Open() (*os.File, error) {
+	if len(f.Path) == 0 {
+		return nil, errors.New("upload was streamed to OnFile and has no temp file")
+	}
+	return os.Open(f.Path)
+}
+
+//Upload is the result of ParseUpload
+type Upload struct {
+	Files  []*UploadedFile
+	Values url.Values
+}
+
+//File returns the first file uploaded in field, or nil
+func (u *Upload) File(field string) *UploadedFile {
+	for _, f := range u.Files {
+		if f.Field == field {
+			return f
+		}
+	}
+	return nil
+}
+
+//RemoveAll removes the temp files, it is called after the ApiHandler returns and is
+//only needed when ParseUpload is used outside of an ApiHandler
+func (u *Upload) RemoveAll() {
+	for _, f := range u.Files {
+		if len(f.Path) > 0 {
+			os.Remove(f.Path)
+		}
+	}
+}
+
+//ParseUpload streams the files of a multipart/form-data request to temp files or to
+//c.OnFile while enforcing the limits of c, and binds the other fields into form if it is
+//not nil. Fields are bound by their `form` tag, then `json` tag, then name. Errors the
+//client caused are *UploadError.
+func ParseUpload(ctx context.Context, req *http.Request, c UploadConfig, form interface{}) (*Upload, error) {
+	if c.MaxFileSize <= 0 {
+		c.MaxFileSize = defaultMaxFileSize
+	}
+	if c.MaxTotalSize <= 0 {
+		c.MaxTotalSize = defaultMaxTotalSize
+	}
+	if c.MaxFiles <= 0 {
+		c.MaxFiles = defaultMaxFiles
+	}
+
+	reader, err := req.MultipartReader()
+	if err != nil {
+		return nil, ErrNotMultipart
+	}
+
+	upload := &Upload{Values: make(url.Values)}
+	//the temp files are removed once the handler returns, or here if parsing fails
+	if !registerCleanup(ctx, upload.RemoveAll) {
+		defer func() {
+			if err != nil {
+				upload.RemoveAll()
+			}
+		}()
+	}
+
+	var total int64
+	for {
+		part, partErr := reader.NextPart()
+		if partErr == io.EOF {
+			break
+		}
+		if partErr != nil {
+			err = &UploadError{Status: http.StatusBadRequest, Message: "malformed multipart body: " + partErr.Error()}
+			return nil, err
+		}
+
+		if len(part.FileName()) == 0 {
+			value, readErr := ioutil.ReadAll(io.LimitReader(part, maxFieldSize+1))
+			part.Close()
+			if readErr != nil {
+				err = readErr
+				return nil, err
+			}
+			total += int64(len(value))
+			if len(value) > maxFieldSize || total > c.MaxTotalSize {
+				err = &UploadError{Status: http.StatusRequestEntityTooLarge, Message: "upload exceeds the size limit"}
+				return nil, err
+			}
+			upload.Values.Add(part.FormName(), string(value))
+			continue
+		}
+
+		if len(upload.Files) >= c.MaxFiles {
+			part.Close()
+			err = &UploadError{Status: http.StatusRequestEntityTooLarge,
+				Message: fmt.Sprintf("upload exceeds %d files", c.MaxFiles)}
+			return nil, err
+		}
+		file, fileErr := c.receive(ctx, part, c.MaxTotalSize-total)
+		part.Close()
+		if file != nil {
+			upload.Files = append(upload.Files, file)
+			total += file.Size
+		}
+		if fileErr != nil {
+			err = fileErr
+			return nil, err
+		}
+	}
+
+	if form != nil {
+		if err = bindForm(upload.Values, form); err != nil {
+			return nil, err
+		}
+	}
+	return upload, nil
+}
+
+//receive sniffs the content type of a file part and stores or streams it within the limits
+func (c UploadConfig) receive(ctx context.Context, part *multipart.Part, remaining int64) (*UploadedFile, error) {
+	file := &UploadedFile{
+		Field:    part.FormName(),
+		Filename: part.FileName(),
+	}
+
+	head := make([]byte, sniffLen)
+	n, err := io.ReadFull(part, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	head = head[:n]
+	file.ContentType = http.DetectContentType(head)
+	if !c.allowed(file.ContentType) {
+		return nil, &UploadError{Status: http.StatusUnsupportedMediaType,
+			Message: fmt.Sprintf("file %s of type %s is not allowed", file.Filename, file.ContentType)}
+	}
+
+	limit := c.MaxFileSize
+	if remaining < limit {
+		limit = remaining
+	}
+	content := &limitedReader{r: io.MultiReader(bytes.NewReader(head), part), remaining: limit}
+
+	if c.OnFile != nil {
+		err = c.OnFile(ctx, file, content)
+		file.Size = content.read
+	} else {
+		var tmp *os.File
+		if tmp, err = ioutil.TempFile(c.TempDir, "orb-upload-"); err != nil {
+			return nil, err
+		}
+		file.Path = tmp.Name()
+		file.Size, err = io.Copy(tmp, content)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if content.exceeded {
+		return file, &UploadError{Status: http.StatusRequestEntityTooLarge,
+			Message: fmt.Sprintf("file %s exceeds the size limit", file.Filename)}
+	}
+	return file, err
+}
+
+func (c UploadConfig) allowed(contentType string) bool {
+	if len(c.AllowedTypes) == 0 {
+		return true
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	for _, allowed := range c.AllowedTypes {
+		if mediaTypeMatches(allowed, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+//limitedReader fails with an error instead of truncating content beyond the limit
+type limitedReader struct {
+	r         io.Reader
+	remaining int64
+	read      int64
+	exceeded  bool
+}
+
+var errUploadLimit = errors.New("upload size limit exceeded")
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, errUploadLimit
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.r.Read(p)
+	if int64(n) > l.remaining {
+		l.exceeded = true
+		n = int(l.remaining)
+		err = errUploadLimit
+	}
+	l.remaining -= int64(n)
+	l.read += int64(n)
+	return n, err
+}
+
+// ----------------------------------------------------------------------------
+// Form binding
+// ---------------------------------------------------------------------------
+
+//bindForm sets the fields of the struct pointed to by v from values
+func bindForm(values url.Values, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("form must be a pointer to a struct")
+	}
+	rv = rv.Elem()
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if len(field.PkgPath) > 0 {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("form"), ",")[0]
+		if len(name) == 0 {
+			name = strings.Split(field.Tag.Get("json"), ",")[0]
+		}
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		fieldValues, ok := values[name]
+		if !ok || len(fieldValues) == 0 {
+			continue
+		}
+
+		fv := rv.Field(i)
+		if fv.Kind() == reflect.Slice && fv.Type().Elem().Kind() != reflect.Uint8 {
+			slice := reflect.MakeSlice(fv.Type(), len(fieldValues), len(fieldValues))
+			for j, value := range fieldValues {
+				if err := setFormValue(slice.Index(j), value); err != nil {
+					return formError(name, err)
+				}
+			}
+			fv.Set(slice)
+			continue
+		}
+		if err := setFormValue(fv, fieldValues[0]); err != nil {
+			return formError(name, err)
+		}
+	}
+	return nil
+}
+
+func setFormValue(v reflect.Value, value string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(value, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(value, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("unsupported field type %s", v.Type())
+	}
+	return nil
+}
+
+func formError(name string, err error) error {
+	return &UploadError{Status: http.StatusBadRequest, Message: fmt.Sprintf("invalid form field %s: %v", name, err)}
+}
+
+// ----------------------------------------------------------------------------
+// Request cleanup
+// ---------------------------------------------------------------------------
+
+const cleanupKey = contextKey("cleanup")
+
+type cleanups struct {
+	sync.Mutex
+	funcs []func()
+}
+
+//registerCleanup runs f once the ApiHandler of ctx has returned, it returns false
+//when ctx does not belong to an ApiHandler
+func registerCleanup(ctx context.Context, f func()) bool {
+	c, ok := ctx.Value(cleanupKey).(*cleanups)
+	if !ok {
+		return false
+	}
+	c.Lock()
+	c.funcs = append(c.funcs, f)
+	c.Unlock()
+	return true
+}
+
+func (c *cleanups) run() {
+	c.Lock()
+	funcs := c.funcs
+	c.funcs = nil
+	c.Unlock()
+	for _, f := range funcs {
+		f()
+	}
+}
@@ -0,0 +1,197 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+var pngHeader = []byte("\x89PNG\x0D\x0A\x1A\x0A" + strings.Repeat("\x00", 32))
+
+type uploadPart struct {
+	field    string
+	filename string
+	content  []byte
+}
+
+func multipartRequest(t *testing.T, parts ...uploadPart) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, p := range parts {
+		var part io.Writer
+		var err error
+		if len(p.filename) > 0 {
+			part, err = w.CreateFormFile(p.field, p.filename)
+		} else {
+			part, err = w.CreateFormField(p.field)
+		}
+		if err != nil {
+			t.Fatalf("create part error = %v", err)
+		}
+		part.Write(p.content)
+	}
+	w.Close()
+	req := httptest.NewRequest(http.MethodPost, "/upload", &body)
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req
+}
+
+type uploadForm struct {
+	Title   string   `form:"title"`
+	Count   int      `json:"count"`
+	Public  bool     `form:"public"`
+	Tags    []string `form:"tag"`
+	Ignored string   `form:"-"`
+}
+
+func TestParseUpload(t *testing.T) {
+	tests := []struct {
+		name   string
+		config UploadConfig
+		parts  []uploadPart
+		status int
+		files  int
+	}{
+		{
+			name:   "file and fields",
+			config: UploadConfig{AllowedTypes: []string{"image/*"}},
+			parts: []uploadPart{
+				{field: "title", content: []byte("logo")},
+				{field: "count", content: []byte("2")},
+				{field: "public", content: []byte("true")},
+				{field: "tag", content: []byte("a")},
+				{field: "tag", content: []byte("b")},
+				{field: "Ignored", content: []byte("x")},
+				{field: "image", filename: "logo.png", content: pngHeader},
+			},
+			files: 1,
+		},
+		{
+			name:   "type not allowed",
+			config: UploadConfig{AllowedTypes: []string{"image/png"}},
+			parts:  []uploadPart{{field: "image", filename: "logo.png", content: []byte("#!/bin/sh\necho")}},
+			status: http.StatusUnsupportedMediaType,
+		},
+		{
+			name:   "file too large",
+			config: UploadConfig{MaxFileSize: 16},
+			parts:  []uploadPart{{field: "doc", filename: "a.txt", content: bytes.Repeat([]byte("a"), 17)}},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "total too large",
+			config: UploadConfig{MaxTotalSize: 20},
+			parts: []uploadPart{
+				{field: "a", filename: "a.txt", content: bytes.Repeat([]byte("a"), 12)},
+				{field: "b", filename: "b.txt", content: bytes.Repeat([]byte("b"), 12)},
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "too many files",
+			config: UploadConfig{MaxFiles: 1},
+			parts: []uploadPart{
+				{field: "a", filename: "a.txt", content: []byte("a")},
+				{field: "b", filename: "b.txt", content: []byte("b")},
+			},
+			status: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name:   "invalid field",
+			parts:  []uploadPart{{field: "count", content: []byte("two")}},
+			status: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "upload")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			tt.config.TempDir = dir
+
+			var form uploadForm
+			var upload *Upload
+			handler := ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+				var err error
+				if upload, err = ParseUpload(ctx, req, tt.config, &form); err != nil {
+					return err, 0
+				}
+				for _, f := range upload.Files {
+					if _, err := os.Stat(f.Path); err != nil {
+						t.Errorf("temp file of %s error = %v", f.Filename, err)
+					}
+				}
+				return "ok", 0
+			})
+
+			rw := httptest.NewRecorder()
+			handler.ServeHTTP(rw, multipartRequest(t, tt.parts...))
+			status := tt.status
+			if status == 0 {
+				status = http.StatusOK
+			}
+			if rw.Code != status {
+				t.Errorf("status = %d, want %d, body %s", rw.Code, status, rw.Body.String())
+			}
+			if entries, _ := ioutil.ReadDir(dir); len(entries) != 0 {
+				t.Errorf("%d temp files left after the handler returned", len(entries))
+			}
+			if tt.status != 0 {
+				return
+			}
+
+			if len(upload.Files) != tt.files {
+				t.Fatalf("files = %d, want %d", len(upload.Files), tt.files)
+			}
+			if f := upload.File("image"); f.ContentType != "image/png" || f.Size != int64(len(pngHeader)) {
+				t.Errorf("file = %+v, want a sniffed image/png of %d bytes", f, len(pngHeader))
+			}
+			want := uploadForm{Title: "logo", Count: 2, Public: true, Tags: []string{"a", "b"}}
+			if form.Title != want.Title || form.Count != want.Count || form.Public != want.Public ||
+				strings.Join(form.Tags, ",") != "a,b" || len(form.Ignored) != 0 {
+				t.Errorf("form = %+v, want %+v", form, want)
+			}
+		})
+	}
+}
+
+func TestParseUpload_OnFile(t *testing.T) {
+	var received bytes.Buffer
+	c := UploadConfig{
+		MaxFileSize: 8,
+		OnFile: func(ctx context.Context, file *UploadedFile, content io.Reader) error {
+			_, err := io.Copy(&received, content)
+			return err
+		},
+	}
+
+	upload, err := ParseUpload(context.Background(),
+		multipartRequest(t, uploadPart{field: "doc", filename: "a.txt", content: []byte("hello")}), c, nil)
+	if err != nil {
+		t.Fatalf("ParseUpload() error = %v", err)
+	}
+	if f := upload.File("doc"); f.Size != 5 || len(f.Path) != 0 || received.String() != "hello" {
+		t.Errorf("file = %+v, received %q, want 5 streamed bytes", f, received.String())
+	}
+
+	_, err = ParseUpload(context.Background(),
+		multipartRequest(t, uploadPart{field: "doc", filename: "a.txt", content: []byte("hello world")}), c, nil)
+	if uploadErr, ok := err.(*UploadError); !ok || uploadErr.Status != http.StatusRequestEntityTooLarge {
+		t.Errorf("ParseUpload() error = %v, want 413", err)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/upload", strings.NewReader("{}"))
+	req.Header.Set("Content-Type", "application/json")
+	if _, err := ParseUpload(context.Background(), req, c, nil); err != ErrNotMultipart {
+		t.Errorf("ParseUpload() error = %v, want %v", err, ErrNotMultipart)
+	}
+}
@@ -2,6 +2,7 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
@@ -9,10 +10,17 @@ import (
 	"time"
 
 	"github.com/v-zhidu/orb/logging"
+	"github.com/v-zhidu/orb/trace"
 )
 
 //Get returns response body that send a GET request to the url
 func Get(url string, params map[string]string) ([]byte, error) {
+	return GetWithContext(context.Background(), url, params)
+}
+
+//GetWithContext returns response body that send a GET request to the url,
+//the request is traced as a child of the span in ctx
+func GetWithContext(ctx context.Context, url string, params map[string]string) ([]byte, error) {
 	defer func() {
 		if err := recover(); err != nil {
 			logging.Error("HTTP GET request failed", logging.Fields{
@@ -29,7 +37,7 @@ func Get(url string, params map[string]string) ([]byte, error) {
 		url = strings.TrimRight(url, "&")
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -39,6 +47,12 @@ func Get(url string, params map[string]string) ([]byte, error) {
 
 //PostJSON returns response body that send a http POST request to the url
 func PostJSON(url string, body []byte, headers map[string]string) ([]byte, error) {
+	return PostJSONWithContext(context.Background(), url, body, headers)
+}
+
+//PostJSONWithContext returns response body that send a http POST request to the url,
+//the request is traced as a child of the span in ctx
+func PostJSONWithContext(ctx context.Context, url string, body []byte, headers map[string]string) ([]byte, error) {
 	defer func() {
 		if err := recover(); err != nil {
 			logging.Error("HTTP POST request failed", logging.Fields{
@@ -48,7 +62,7 @@ func PostJSON(url string, body []byte, headers map[string]string) ([]byte, error
 	}()
 
 	b := bytes.NewBuffer([]byte(body))
-	req, err := http.NewRequest(http.MethodPost, url, b)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, b)
 	if err != nil {
 		return nil, err
 	}
@@ -64,16 +78,30 @@ func PostJSON(url string, body []byte, headers map[string]string) ([]byte, error
 
 //PostMap wapper of HTTPPost method
 func PostMap(url string, body map[string]interface{}, headers map[string]string) ([]byte, error) {
+	return PostMapWithContext(context.Background(), url, body, headers)
+}
+
+//PostMapWithContext wapper of PostJSONWithContext method
+func PostMapWithContext(ctx context.Context, url string, body map[string]interface{},
+	headers map[string]string) ([]byte, error) {
 	data, err := json.Marshal(body)
 	if err != nil {
 		return nil, err
 	}
 
-	return PostJSON(url, data, headers)
+	return PostJSONWithContext(ctx, url, data, headers)
 }
 
 func doRequest(req *http.Request) ([]byte, error) {
 	reqURL := req.Host + req.URL.RequestURI()
+	ctx, span := trace.Start(req.Context(), "HTTP "+req.Method, trace.SpanKindClient)
+	defer span.End()
+	span.SetAttribute("http.method", req.Method)
+	//the query is left out, callers such as wechat pass credentials in it
+	span.SetAttribute("http.url", req.URL.Scheme+"://"+req.Host+req.URL.Path)
+	req = req.WithContext(ctx)
+	trace.Inject(ctx, req.Header)
+
 	logging.Debug("Exeute HTTP request", logging.Fields{
 		"url":  reqURL,
 		"body": req.Body,
@@ -82,6 +110,7 @@ func doRequest(req *http.Request) ([]byte, error) {
 	client := http.Client{}
 	res, err := client.Do(req)
 	if err != nil {
+		span.RecordError(err)
 		logging.Error("excute HTTP request failed", logging.Fields{
 			"url":  reqURL,
 			"body": req.Body,
@@ -89,6 +118,7 @@ func doRequest(req *http.Request) ([]byte, error) {
 		return nil, err
 	}
 	defer res.Body.Close()
+	span.SetAttribute("http.status_code", res.StatusCode)
 
 	logging.Debug("HTTP returns response", logging.Fields{
 		"url":      reqURL,
@@ -99,6 +129,7 @@ func doRequest(req *http.Request) ([]byte, error) {
 
 	data, err := ioutil.ReadAll(res.Body)
 	if err != nil {
+		span.RecordError(err)
 		logging.WithError("read response body err", err)
 		return nil, err
 	}
@@ -0,0 +1,228 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/v-zhidu/orb/logging"
+)
+
+const defaultVersionHeader = "API-Version"
+
+//VersioningConfig configures how the requests to unversioned paths select a version
+type VersioningConfig struct {
+	//Header is the request header naming the version, e.g. API-Version: v2, API-Version by default
+	Header string
+	//Vendor selects the version by the Accept media type application/vnd.<Vendor>.<version>+json,
+	//e.g. application/vnd.orb.v2+json, disabled when empty. The version parameter of
+	//application/json, e.g. application/json; version=v2, is always accepted.
+	Vendor string
+	//Default is the version of the requests naming none, the first version registered
+	//for the path by default so that new versions do not change the existing clients
+	Default string
+}
+
+//WithVersioning configures the version selection of the routes registered by Version groups
+func WithVersioning(c VersioningConfig) ServerOption {
+	return func(s *HTTPServer) {
+		s.versioning = c
+	}
+}
+
+//WithDeprecation marks the route deprecated since the given time with the Deprecation header,
+//sunset sets the Sunset header when it is not zero and link documents the migration.
+//Every call of a deprecated route is logged and counted in the admin /routes endpoint.
+func WithDeprecation(since time.Time, sunset time.Time, link string) RouteOption {
+	return func(r *route) {
+		r.deprecation = &deprecation{since: since, sunset: sunset, link: link}
+	}
+}
+
+//VersionGroup registers the routes of one API version
+type VersionGroup struct {
+	server  *HTTPServer
+	version string
+	opts    []RouteOption
+}
+
+//Version returns the group of the routes of version, e.g. v1. A route of the group
+//registered for url answers at <prefix>/v1<url>, and at <prefix><url> for the requests
+//selecting v1 as configured by WithVersioning. opts apply to every route of the group,
+//e.g. WithDeprecation for a version being phased out.
+func (s *HTTPServer) Version(version string, opts ...RouteOption) *VersionGroup {
+	return &VersionGroup{server: s, version: version, opts: opts}
+}
+
+//RegisterApiHandler registers handler for url in the version
+func (g *VersionGroup) RegisterApiHandler(url string, handler ApiHandler, opts ...RouteOption) {
+	g.server.RegisterApiHandler("/"+g.version+url, handler, g.routeOptions(opts)...)
+	g.server.registerVersion(url, g.version)
+}
+
+//RegisterStreamHandler registers handler for url in the version
+func (g *VersionGroup) RegisterStreamHandler(url string, handler StreamHandler, opts ...RouteOption) {
+	g.server.RegisterStreamHandler("/"+g.version+url, handler, g.routeOptions(opts)...)
+	g.server.registerVersion(url, g.version)
+}
+
+func (g *VersionGroup) routeOptions(opts []RouteOption) []RouteOption {
+	version := g.version
+	return append(append(append([]RouteOption{}, g.opts...), func(r *route) {
+		r.version = version
+	}), opts...)
+}
+
+//registerVersion serves the unversioned url by the version selected by the request
+func (s *HTTPServer) registerVersion(url string, version string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.versions == nil {
+		s.versions = make(map[string]*versionSelector)
+	}
+	selector, ok := s.versions[url]
+	if !ok {
+		selector = &versionSelector{
+			config: s.versioning,
+			prefix: s.prefix,
+			mux:    s.mux,
+		}
+		s.versions[url] = selector
+		//the selected route logs the requests, the selector only logs those it rejects
+		var h http.Handler = selector
+		if s.accessLog != nil {
+			h = s.accessLog(h)
+		}
+		s.mux.Handle(s.prefix+url, h)
+	}
+	selector.add(version)
+}
+
+type versionSelector struct {
+	config   VersioningConfig
+	prefix   string
+	mux      *http.ServeMux
+	versions atomic.Value
+}
+
+func (v *versionSelector) add(version string) {
+	versions, _ := v.versions.Load().([]string)
+	v.versions.Store(append(append([]string{}, versions...), version))
+}
+
+func (v *versionSelector) header() string {
+	if len(v.config.Header) == 0 {
+		return defaultVersionHeader
+	}
+	return v.config.Header
+}
+
+func (v *versionSelector) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	versions, _ := v.versions.Load().([]string)
+	rw.Header().Add("Vary", v.header())
+
+	requested, vendor := v.requested(r)
+	version := v.config.Default
+	if len(requested) > 0 {
+		version = v.match(versions, requested)
+	} else if !containsString(versions, version) {
+		version = versions[0]
+	}
+	if len(version) == 0 {
+		sorted := append([]string{}, versions...)
+		sort.Strings(sorted)
+		WriteError(rw, http.StatusBadRequest,
+			"unsupported API version "+requested+", supported versions are "+strings.Join(sorted, ", "))
+		return
+	}
+
+	//the route of the version serves the request as if its versioned path was requested
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = v.prefix + "/" + version + strings.TrimPrefix(r.URL.Path, v.prefix)
+	r2.URL.RawPath = ""
+	if vendor {
+		r2.Header.Set("Accept", "application/json")
+	}
+	rw.Header().Set(v.header(), version)
+	v.mux.ServeHTTP(rw, r2)
+}
+
+//requested returns the version named by the request, vendor is true when it is named
+//by a vendor media type the encoders do not know
+func (v *versionSelector) requested(r *http.Request) (string, bool) {
+	if version := strings.TrimSpace(r.Header.Get(v.header())); len(version) > 0 {
+		return version, false
+	}
+	vendorPrefix := "application/vnd." + strings.ToLower(v.config.Vendor) + "."
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		fields := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(fields[0]))
+		if len(v.config.Vendor) > 0 && strings.HasPrefix(mediaType, vendorPrefix) {
+			return strings.TrimSuffix(strings.TrimPrefix(mediaType, vendorPrefix), "+json"), true
+		}
+		if mediaType != "application/json" {
+			continue
+		}
+		for _, param := range fields[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) == 2 && strings.ToLower(kv[0]) == "version" {
+				return strings.Trim(kv[1], `"`), false
+			}
+		}
+	}
+	return "", false
+}
+
+//match returns the registered version named requested, v2 and 2 both name v2
+func (v *versionSelector) match(versions []string, requested string) string {
+	for _, version := range versions {
+		if strings.EqualFold(version, requested) || strings.EqualFold(version, "v"+requested) {
+			return version
+		}
+	}
+	return ""
+}
+
+// ----------------------------------------------------------------------------
+// Deprecation
+// ---------------------------------------------------------------------------
+
+type deprecation struct {
+	since  time.Time
+	sunset time.Time
+	link   string
+	calls  int64
+}
+
+//deprecationHandler sets the RFC 9745 Deprecation and RFC 8594 Sunset headers and
+//logs the callers of the deprecated route so that they can be migrated
+func deprecationHandler(r *route, next http.Handler) http.Handler {
+	d := r.deprecation
+	return http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		header := rw.Header()
+		header.Set("Deprecation", "@"+strconv.FormatInt(d.since.Unix(), 10))
+		if !d.sunset.IsZero() {
+			header.Set("Sunset", d.sunset.UTC().Format(http.TimeFormat))
+		}
+		if len(d.link) > 0 {
+			header.Add("Link", "<"+d.link+`>; rel="deprecation"`)
+		}
+
+		atomic.AddInt64(&d.calls, 1)
+		fields := logging.Fields{
+			"route":     r.pattern,
+			"url":       req.RequestURI,
+			"remote":    req.RemoteAddr,
+			"userAgent": req.UserAgent(),
+		}
+		if principal := GetPrincipal(req.Context()); principal != nil {
+			fields["principal"] = principal.ID
+		}
+		logging.Warn("deprecated route called", fields)
+
+		next.ServeHTTP(rw, req)
+	})
+}
@@ -0,0 +1,89 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHTTPServer_Version(t *testing.T) {
+	since := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2024, 7, 1, 0, 0, 0, 0, time.UTC)
+
+	s := NewHTTPServer("localhost", 0, "/api", WithoutAccessLog(), WithVersioning(VersioningConfig{Vendor: "orb"}))
+	v1 := s.Version("v1", WithDeprecation(since, sunset, "https://docs.example.com/migrate-v2"))
+	v2 := s.Version("v2")
+	version := func(name string) ApiHandlerFunc {
+		return func(ctx context.Context, req *http.Request) (interface{}, int) {
+			return map[string]string{"version": name, "path": req.URL.Path}, 0
+		}
+	}
+	v1.RegisterApiHandler("/alerts", version("v1"))
+	v2.RegisterApiHandler("/alerts", version("v2"))
+
+	tests := []struct {
+		name       string
+		url        string
+		header     map[string]string
+		status     int
+		version    string
+		deprecated bool
+	}{
+		{"path v1", "/api/v1/alerts", nil, 200, "v1", true},
+		{"path v2", "/api/v2/alerts", nil, 200, "v2", false},
+		{"default is the first version", "/api/alerts", nil, 200, "v1", true},
+		{"version header", "/api/alerts", map[string]string{"API-Version": "v2"}, 200, "v2", false},
+		{"version header number", "/api/alerts", map[string]string{"API-Version": "2"}, 200, "v2", false},
+		{"vendor media type", "/api/alerts", map[string]string{"Accept": "application/vnd.orb.v2+json"}, 200, "v2", false},
+		{"media type parameter", "/api/alerts", map[string]string{"Accept": "application/json; version=v1"}, 200, "v1", true},
+		{"unknown version", "/api/alerts", map[string]string{"API-Version": "v9"}, 400, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			for key, value := range tt.header {
+				req.Header.Set(key, value)
+			}
+			rw := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rw, req)
+
+			if rw.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body %s", rw.Code, tt.status, rw.Body.String())
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			want := `{"path":"/api/` + tt.version + `/alerts","version":"` + tt.version + `"}` + "\n"
+			if rw.Body.String() != want {
+				t.Errorf("body = %s, want %s", rw.Body.String(), want)
+			}
+
+			wantHeaders := map[string]string{"Deprecation": "", "Sunset": "", "Link": ""}
+			if tt.deprecated {
+				wantHeaders = map[string]string{
+					"Deprecation": "@1704067200",
+					"Sunset":      "Mon, 01 Jul 2024 00:00:00 GMT",
+					"Link":        `<https://docs.example.com/migrate-v2>; rel="deprecation"`,
+				}
+			}
+			for key, value := range wantHeaders {
+				if got := rw.Header().Get(key); got != value {
+					t.Errorf("%s = %q, want %q", key, got, value)
+				}
+			}
+		})
+	}
+
+	for _, r := range s.routes {
+		if r.pattern == "/api/v1/alerts" && r.deprecation.calls != 3 {
+			t.Errorf("deprecated calls = %d, want 3", r.deprecation.calls)
+		}
+	}
+	spec := s.OpenAPI(OpenAPIInfo{Title: "alerts", Version: "1.0"})
+	op := spec["paths"].(map[string]interface{})["/api/v1/alerts"].(map[string]interface{})["get"].(map[string]interface{})
+	if op["deprecated"] != true {
+		t.Errorf("OpenAPI operation = %v, want deprecated", op)
+	}
+}
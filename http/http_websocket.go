@@ -0,0 +1,544 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"github.com/v-zhidu/orb/logging"
+)
+
+const (
+	defaultSendBuffer     = 64
+	defaultPingInterval   = 30 * time.Second
+	defaultMaxMessageSize = 64 * 1024
+	websocketWriteWait    = 10 * time.Second
+	//maxCloseReason is the control frame payload limit less the 2 bytes of the close code
+	maxCloseReason = 123
+)
+
+var (
+	//ErrSlowConsumer is returned by Send when the send buffer of the connection is full,
+	//the connection is closed
+	ErrSlowConsumer = errors.New("websocket slow consumer")
+	//ErrConnectionClosed is returned once the websocket connection is closed
+	ErrConnectionClosed = errors.New("websocket connection closed")
+)
+
+//WebSocketHandler serves an upgraded websocket connection, the connection is
+//closed when ServeWebSocket returns
+type WebSocketHandler interface {
+	ServeWebSocket(ctx context.Context, conn *WebSocketConn) error
+}
+
+//WebSocketHandlerFunc is an adapter to use ordinary functions as WebSocketHandler
+type WebSocketHandlerFunc func(context.Context, *WebSocketConn) error
+
+//ServeWebSocket implement WebSocketHandler interface
+func (f WebSocketHandlerFunc) ServeWebSocket(ctx context.Context, conn *WebSocketConn) error {
+	return f(ctx, conn)
+}
+
+//WithSendBuffer set how many messages are queued for a websocket connection
+//before it is evicted as a slow consumer
+func WithSendBuffer(size int) RouteOption {
+	return func(r *route) {
+		r.sendBuffer = size
+	}
+}
+
+//WithPingInterval set the interval of websocket pings, connections are closed
+//when no pong is received within two intervals
+func WithPingInterval(interval time.Duration) RouteOption {
+	return func(r *route) {
+		r.pingInterval = interval
+	}
+}
+
+//WithCheckOrigin replaces the same origin check of websocket upgrades
+func WithCheckOrigin(check func(*http.Request) bool) RouteOption {
+	return func(r *route) {
+		r.checkOrigin = check
+	}
+}
+
+//RegisterWebSocketHandler registers a websocket handler for url, open connections
+//are closed with "going away" when the server shuts down
+func (s *HTTPServer) RegisterWebSocketHandler(url string, handler WebSocketHandler, opts ...RouteOption) {
+	if len(url) == 0 {
+		logging.Errorln("register url is invalid")
+	}
+
+	logging.Debug("mapping websocket handler", logging.Fields{
+		"prefix":  s.prefix,
+		"url":     url,
+		"handler": reflect.TypeOf(handler),
+	})
+	route := newRoute(url, append([]RouteOption{
+		WithSendBuffer(defaultSendBuffer),
+		WithPingInterval(defaultPingInterval),
+	}, opts...))
+	s.handle(route, "websocket", handler, &websocketHandler{
+		server:  s,
+		handler: handler,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			CheckOrigin:     route.checkOrigin,
+		},
+		sendBuffer:   route.sendBuffer,
+		pingInterval: route.pingInterval,
+	})
+}
+
+type websocketHandler struct {
+	server       *HTTPServer
+	handler      WebSocketHandler
+	upgrader     websocket.Upgrader
+	sendBuffer   int
+	pingInterval time.Duration
+}
+
+func (h *websocketHandler) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if h.server.health.IsShuttingDown() {
+		WriteError(rw, http.StatusServiceUnavailable, "server is shutting down")
+		return
+	}
+	ws, err := h.upgrader.Upgrade(rw, r, nil)
+	if err != nil {
+		//the upgrader has replied with an error status
+		logging.Warn("websocket upgrade failed", logging.Fields{
+			"url":   r.RequestURI,
+			"error": err.Error(),
+		})
+		return
+	}
+
+	//the hijacked connection outlives the request context
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &WebSocketConn{
+		Request:      r,
+		ws:           ws,
+		send:         make(chan []byte, h.sendBuffer),
+		inbox:        make(chan json.RawMessage, h.sendBuffer),
+		ctx:          ctx,
+		cancel:       cancel,
+		pingInterval: h.pingInterval,
+	}
+	h.server.trackWebSocket(conn, true)
+	defer h.server.trackWebSocket(conn, false)
+
+	logging.Info("websocket connected", logging.Fields{
+		"url": r.RequestURI,
+		"ip":  r.RemoteAddr,
+	})
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		conn.writePump()
+		close(done)
+	}()
+	go conn.readPump()
+
+	if err := h.handler.ServeWebSocket(ctx, conn); err != nil && err != ErrConnectionClosed {
+		logging.Error("websocket handler failed", logging.Fields{
+			"url": r.RequestURI,
+		}, err)
+		conn.closeWith(websocket.CloseInternalServerErr, err.Error())
+	}
+	conn.Close()
+	<-done
+
+	logging.Info("websocket disconnected", logging.Fields{
+		"url":      r.RequestURI,
+		"duration": time.Since(start),
+	})
+}
+
+//trackWebSocket adds or removes an open connection closed by Shutdown
+func (s *HTTPServer) trackWebSocket(conn *WebSocketConn, open bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.websockets == nil {
+		s.websockets = make(map[*WebSocketConn]bool)
+	}
+	if open {
+		s.websockets[conn] = true
+		s.websocketsGroup.Add(1)
+	} else {
+		delete(s.websockets, conn)
+		s.websocketsGroup.Done()
+	}
+}
+
+//closeWebSockets closes the open connections with "going away" and waits for
+//their handlers to return, hijacked connections are not drained by http.Server
+func (s *HTTPServer) closeWebSockets(ctx context.Context) error {
+	s.mu.Lock()
+	conns := make([]*WebSocketConn, 0, len(s.websockets))
+	for conn := range s.websockets {
+		conns = append(conns, conn)
+	}
+	s.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.closeWith(websocket.CloseGoingAway, "server is shutting down")
+	}
+
+	return waitGroup(ctx, &s.websocketsGroup)
+}
+
+// ----------------------------------------------------------------------------
+// Connection
+// ---------------------------------------------------------------------------
+
+//WebSocketConn is an upgraded websocket connection exchanging JSON text messages
+type WebSocketConn struct {
+	//Request is the upgrade request of the connection
+	Request *http.Request
+
+	ws           *websocket.Conn
+	send         chan []byte
+	inbox        chan json.RawMessage
+	ctx          context.Context
+	cancel       context.CancelFunc
+	pingInterval time.Duration
+
+	mu          sync.Mutex
+	closeCode   int
+	closeReason string
+	onClose     []func()
+}
+
+//Context returns a context cancelled when the connection is closed
+func (c *WebSocketConn) Context() context.Context {
+	return c.ctx
+}
+
+//Send queues v as a JSON text message, the connection is evicted when its send buffer is full
+func (c *WebSocketConn) Send(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.sendRaw(data)
+}
+
+func (c *WebSocketConn) sendRaw(data []byte) error {
+	select {
+	case <-c.ctx.Done():
+		return ErrConnectionClosed
+	default:
+	}
+
+	select {
+	case c.send <- data:
+		return nil
+	default:
+		logging.Warn("websocket slow consumer evicted", logging.Fields{
+			"url": c.Request.RequestURI,
+			"ip":  c.Request.RemoteAddr,
+		})
+		c.closeWith(websocket.ClosePolicyViolation, "slow consumer")
+		return ErrSlowConsumer
+	}
+}
+
+//Receive waits for the next message and decodes it into v
+func (c *WebSocketConn) Receive(v interface{}) error {
+	select {
+	case <-c.ctx.Done():
+		return ErrConnectionClosed
+	case data := <-c.inbox:
+		return json.Unmarshal(data, v)
+	}
+}
+
+//Close closes the connection normally
+func (c *WebSocketConn) Close() {
+	c.closeWith(websocket.CloseNormalClosure, "")
+}
+
+//OnClose registers a function called once the connection is closed, it is called
+//at once when the connection is already closed
+func (c *WebSocketConn) OnClose(f func()) {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		f()
+		return
+	}
+	c.onClose = append(c.onClose, f)
+	c.mu.Unlock()
+}
+
+func (c *WebSocketConn) closeWith(code int, reason string) {
+	c.mu.Lock()
+	if c.ctx.Err() != nil {
+		c.mu.Unlock()
+		return
+	}
+	c.closeCode, c.closeReason = code, truncateCloseReason(reason)
+	hooks := c.onClose
+	c.cancel()
+	c.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook()
+	}
+}
+
+func (c *WebSocketConn) readPump() {
+	defer c.closeWith(websocket.CloseNormalClosure, "")
+
+	pongWait := 2 * c.pingInterval
+	c.ws.SetReadLimit(defaultMaxMessageSize)
+	c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.ws.SetPongHandler(func(string) error {
+		return c.ws.SetReadDeadline(time.Now().Add(pongWait))
+	})
+
+	for {
+		messageType, data, err := c.ws.ReadMessage()
+		if err != nil {
+			return
+		}
+		if messageType != websocket.TextMessage || !json.Valid(data) {
+			c.closeWith(websocket.CloseInvalidFramePayloadData, "message must be JSON text")
+			return
+		}
+		select {
+		case c.inbox <- json.RawMessage(data):
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+func (c *WebSocketConn) writePump() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.ws.Close()
+	}()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				c.closeWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-ticker.C:
+			c.ws.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+			if err := c.ws.WriteMessage(websocket.PingMessage, nil); err != nil {
+				c.closeWith(websocket.CloseAbnormalClosure, "")
+				return
+			}
+		case <-c.ctx.Done():
+			c.mu.Lock()
+			code, reason := c.closeCode, c.closeReason
+			c.mu.Unlock()
+			if code == websocket.CloseNormalClosure {
+				c.drain()
+			}
+			if code != websocket.CloseAbnormalClosure {
+				c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason),
+					time.Now().Add(websocketWriteWait))
+			}
+			return
+		}
+	}
+}
+
+//truncateCloseReason cuts reason to the 123 bytes left for it in a close frame,
+//keeping whole UTF-8 characters
+func truncateCloseReason(reason string) string {
+	if len(reason) <= maxCloseReason {
+		return reason
+	}
+	reason = reason[:maxCloseReason]
+	for len(reason) > 0 && !utf8.ValidString(reason) {
+		reason = reason[:len(reason)-1]
+	}
+	return reason
+}
+
+//drain writes the messages queued before a normal closure
+func (c *WebSocketConn) drain() {
+	for {
+		select {
+		case data := <-c.send:
+			c.ws.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+			if err := c.ws.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Hub
+// ---------------------------------------------------------------------------
+
+//WebSocketMessage is the envelope of messages exchanged through a Hub
+type WebSocketMessage struct {
+	Type  string          `json:"type"`
+	Topic string          `json:"topic,omitempty"`
+	Data  json.RawMessage `json:"data,omitempty"`
+}
+
+const (
+	//MessageSubscribe is sent by clients to subscribe a topic
+	MessageSubscribe = "subscribe"
+	//MessageUnsubscribe is sent by clients to unsubscribe a topic
+	MessageUnsubscribe = "unsubscribe"
+	//MessagePublish is sent by the hub for every published message
+	MessagePublish = "publish"
+)
+
+//Hub broadcasts messages to the connections subscribed to a topic
+type Hub struct {
+	sync.RWMutex
+	topics map[string]map[*WebSocketConn]bool
+	//conns are the topics of every connection, a connection is known from its first
+	//subscription until it is closed so that a single cleanup is registered for it
+	conns map[*WebSocketConn]map[string]bool
+}
+
+//NewHub returns a Hub without subscriptions
+func NewHub() *Hub {
+	return &Hub{
+		topics: make(map[string]map[*WebSocketConn]bool),
+		conns:  make(map[*WebSocketConn]map[string]bool),
+	}
+}
+
+//Subscribe adds conn to the topic until it is unsubscribed or closed, it returns
+//ErrConnectionClosed when conn is closed
+func (h *Hub) Subscribe(conn *WebSocketConn, topic string) error {
+	if conn.ctx.Err() != nil {
+		return ErrConnectionClosed
+	}
+
+	h.Lock()
+	topics, known := h.conns[conn]
+	if !known {
+		topics = make(map[string]bool)
+		h.conns[conn] = topics
+	}
+	topics[topic] = true
+	conns, ok := h.topics[topic]
+	if !ok {
+		conns = make(map[*WebSocketConn]bool)
+		h.topics[topic] = conns
+	}
+	conns[conn] = true
+	h.Unlock()
+
+	if !known {
+		//runs at once when conn has been closed in the meantime
+		conn.OnClose(func() {
+			h.remove(conn)
+		})
+	}
+	return nil
+}
+
+//Unsubscribe removes conn from the topic
+func (h *Hub) Unsubscribe(conn *WebSocketConn, topic string) {
+	h.Lock()
+	defer h.Unlock()
+	delete(h.conns[conn], topic)
+	h.unsubscribe(conn, topic)
+}
+
+//remove forgets the subscriptions of a closed connection
+func (h *Hub) remove(conn *WebSocketConn) {
+	h.Lock()
+	defer h.Unlock()
+	for topic := range h.conns[conn] {
+		h.unsubscribe(conn, topic)
+	}
+	delete(h.conns, conn)
+}
+
+func (h *Hub) unsubscribe(conn *WebSocketConn, topic string) {
+	if conns, ok := h.topics[topic]; ok {
+		delete(conns, conn)
+		if len(conns) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
+
+//Subscribers returns how many connections subscribe the topic
+func (h *Hub) Subscribers(topic string) int {
+	h.RLock()
+	defer h.RUnlock()
+	return len(h.topics[topic])
+}
+
+//Publish sends v to every connection subscribed to the topic and returns how
+//many connections it was queued for, slow consumers are evicted
+func (h *Hub) Publish(topic string, v interface{}) (int, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	message, err := json.Marshal(WebSocketMessage{
+		Type:  MessagePublish,
+		Topic: topic,
+		Data:  data,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	h.RLock()
+	conns := make([]*WebSocketConn, 0, len(h.topics[topic]))
+	for conn := range h.topics[topic] {
+		conns = append(conns, conn)
+	}
+	h.RUnlock()
+
+	sent := 0
+	for _, conn := range conns {
+		if conn.sendRaw(message) == nil {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+//ServeWebSocket implement WebSocketHandler interface, clients manage their
+//subscriptions with {"type":"subscribe","topic":"..."} messages
+func (h *Hub) ServeWebSocket(ctx context.Context, conn *WebSocketConn) error {
+	for {
+		var message WebSocketMessage
+		if err := conn.Receive(&message); err != nil {
+			if err == ErrConnectionClosed {
+				return nil
+			}
+			return err
+		}
+
+		switch message.Type {
+		case MessageSubscribe:
+			if err := h.Subscribe(conn, message.Topic); err != nil {
+				return nil
+			}
+		case MessageUnsubscribe:
+			h.Unsubscribe(conn, message.Topic)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func dialWebSocket(t *testing.T, url string) *websocket.Conn {
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	ws.SetReadDeadline(time.Now().Add(2 * time.Second))
+	return ws
+}
+
+func TestWebSocketHandler_Echo(t *testing.T) {
+	s := NewHTTPServer("localhost", 0, "/api")
+	s.RegisterWebSocketHandler("/echo", WebSocketHandlerFunc(func(ctx context.Context, conn *WebSocketConn) error {
+		for {
+			var msg map[string]interface{}
+			if err := conn.Receive(&msg); err != nil {
+				return err
+			}
+			msg["echo"] = true
+			if err := conn.Send(msg); err != nil {
+				return err
+			}
+		}
+	}))
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	ws := dialWebSocket(t, server.URL+"/api/echo")
+	defer ws.Close()
+
+	ws.WriteJSON(map[string]string{"alert": "disk full"})
+	var got map[string]interface{}
+	if err := ws.ReadJSON(&got); err != nil {
+		t.Fatalf("ReadJSON() error = %v", err)
+	}
+	if got["alert"] != "disk full" || got["echo"] != true {
+		t.Errorf("echo = %v", got)
+	}
+
+	ws.WriteMessage(websocket.TextMessage, []byte("not json"))
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseInvalidFramePayloadData) {
+		t.Errorf("invalid message close error = %v", err)
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	s := NewHTTPServer("localhost", 0, "")
+	s.RegisterWebSocketHandler("/events", hub, WithSendBuffer(1))
+	server := httptest.NewServer(s.Handler())
+	defer server.Close()
+
+	alerts := dialWebSocket(t, server.URL+"/events")
+	defer alerts.Close()
+	progress := dialWebSocket(t, server.URL+"/events")
+	defer progress.Close()
+
+	alerts.WriteJSON(WebSocketMessage{Type: MessageSubscribe, Topic: "alerts"})
+	progress.WriteJSON(WebSocketMessage{Type: MessageSubscribe, Topic: "sync"})
+	for hub.Subscribers("alerts") != 1 || hub.Subscribers("sync") != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if n, err := hub.Publish("alerts", map[string]string{"content": "disk full"}); err != nil || n != 1 {
+		t.Fatalf("Publish() = %v, %v", n, err)
+	}
+	var got WebSocketMessage
+	if err := alerts.ReadJSON(&got); err != nil {
+		t.Fatalf("ReadJSON() error = %v", err)
+	}
+	if got.Type != MessagePublish || got.Topic != "alerts" || string(got.Data) != `{"content":"disk full"}` {
+		t.Errorf("published message = %+v, data %s", got, got.Data)
+	}
+
+	//the sync subscriber never reads, it is evicted once its buffer of one message is full
+	evicted := false
+	for i := 0; i < 10000 && !evicted; i++ {
+		hub.Publish("sync", strings.Repeat("progress", 1024))
+		evicted = hub.Subscribers("sync") == 0
+	}
+	if !evicted {
+		t.Fatalf("slow consumer is not evicted")
+	}
+	progress.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := progress.ReadMessage(); err != nil {
+			if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Errorf("slow consumer close error = %v", err)
+			}
+			break
+		}
+	}
+}
+
+func TestHub_Subscriptions(t *testing.T) {
+	hub := NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	conn := &WebSocketConn{ctx: ctx, cancel: cancel}
+
+	for i := 0; i < 100; i++ {
+		if err := hub.Subscribe(conn, "alerts"); err != nil {
+			t.Fatalf("Subscribe() error = %v", err)
+		}
+		hub.Unsubscribe(conn, "alerts")
+	}
+	hub.Subscribe(conn, "sync")
+	if len(conn.onClose) != 1 {
+		t.Errorf("close hooks = %d, want a single hub cleanup", len(conn.onClose))
+	}
+
+	conn.Close()
+	if hub.Subscribers("sync") != 0 || len(hub.conns) != 0 {
+		t.Errorf("closed connection is still subscribed: %v", hub.topics)
+	}
+	if err := hub.Subscribe(conn, "sync"); err != ErrConnectionClosed || hub.Subscribers("sync") != 0 {
+		t.Errorf("Subscribe() of a closed connection error = %v, subscribers %d", err, hub.Subscribers("sync"))
+	}
+}
+
+func TestTruncateCloseReason(t *testing.T) {
+	tests := []struct {
+		reason string
+		want   int
+	}{
+		{"slow consumer", 13},
+		{strings.Repeat("a", 200), 123},
+		//a 3 byte character would cross the limit
+		{strings.Repeat("a", 122) + "告警", 122},
+	}
+	for _, tt := range tests {
+		if got := truncateCloseReason(tt.reason); len(got) != tt.want {
+			t.Errorf("truncateCloseReason(%q) = %d bytes, want %d", tt.reason, len(got), tt.want)
+		}
+	}
+}
+
+func TestWebSocketHandler_Shutdown(t *testing.T) {
+	s := NewHTTPServer("127.0.0.1", 0, "")
+	s.RegisterWebSocketHandler("/events", NewHub())
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	ws := dialWebSocket(t, "http://"+s.Addr()+"/events")
+	defer ws.Close()
+
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- s.Shutdown(context.Background())
+	}()
+
+	_, _, err := ws.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("shutdown close error = %v", err)
+	}
+	if err := <-shutdown; err != nil {
+		t.Errorf("Shutdown() error = %v", err)
+	}
+
+	rw := httptest.NewRecorder()
+	s.Handler().ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/events", nil))
+	if rw.Code != http.StatusServiceUnavailable {
+		t.Errorf("upgrade after shutdown code = %v", rw.Code)
+	}
+}
@@ -0,0 +1,308 @@
+package httptest
+
+//This is a package that makes testing orb handlers easier, requests are built
+//fluently, served in-process and the responses asserted on.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	orbhttp "github.com/v-zhidu/orb/http"
+)
+
+var update = flag.Bool("update-golden", false, "rewrite the golden files with the actual responses")
+
+//Request builds a request served in-process by Do, Serve or ServeServer
+type Request struct {
+	method    string
+	target    string
+	header    http.Header
+	query     url.Values
+	body      []byte
+	ctx       context.Context
+	principal *orbhttp.Principal
+}
+
+//NewRequest starts building a request of method for target, a path with an optional query
+func NewRequest(method string, target string) *Request {
+	return &Request{
+		method: method,
+		target: target,
+		header: make(http.Header),
+		query:  make(url.Values),
+		ctx:    context.Background(),
+	}
+}
+
+//Get starts building a GET request
+func Get(target string) *Request {
+	return NewRequest(http.MethodGet, target)
+}
+
+//Post starts building a POST request
+func Post(target string) *Request {
+	return NewRequest(http.MethodPost, target)
+}
+
+//WithHeader adds a request header
+func (r *Request) WithHeader(key string, value string) *Request {
+	r.header.Add(key, value)
+	return r
+}
+
+//WithQuery adds a query parameter
+func (r *Request) WithQuery(key string, value string) *Request {
+	r.query.Add(key, value)
+	return r
+}
+
+//WithBody sets the request body and its content type
+func (r *Request) WithBody(contentType string, body []byte) *Request {
+	r.header.Set("Content-Type", contentType)
+	r.body = body
+	return r
+}
+
+//WithJSON sets v encoded as JSON as the request body
+func (r *Request) WithJSON(v interface{}) *Request {
+	body, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return r.WithBody("application/json", body)
+}
+
+//WithContext sets the context of the request
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r.ctx = ctx
+	return r
+}
+
+//WithPrincipal serves the request as if the Authenticate middleware had authenticated principal
+func (r *Request) WithPrincipal(principal *orbhttp.Principal) *Request {
+	r.principal = principal
+	return r
+}
+
+//Build returns the http request
+func (r *Request) Build() *http.Request {
+	var body io.Reader
+	if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+	req := httptest.NewRequest(r.method, r.target, body)
+	for key, values := range r.header {
+		req.Header[key] = append([]string(nil), values...)
+	}
+	if len(r.query) > 0 {
+		query := req.URL.Query()
+		for key, values := range r.query {
+			query[key] = append(query[key], values...)
+		}
+		req.URL.RawQuery = query.Encode()
+		req.RequestURI = req.URL.RequestURI()
+	}
+
+	ctx := r.ctx
+	if r.principal != nil {
+		ctx = context.WithValue(ctx, orbhttp.PrincipalKey, r.principal)
+	}
+	return req.WithContext(ctx)
+}
+
+//Do serves the request with handler
+func (r *Request) Do(t testing.TB, handler http.Handler) *Response {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, r.Build())
+	return &Response{
+		t:      t,
+		Code:   rec.Code,
+		Header: rec.Header(),
+		Body:   rec.Body.Bytes(),
+	}
+}
+
+//Serve serves the request with an ApiHandler the way HTTPServer does, including
+//the status, headers and cookies set with GetResponse and the response encoding
+func (r *Request) Serve(t testing.TB, handler orbhttp.ApiHandler) *Response {
+	t.Helper()
+	return r.Do(t, orbhttp.ApiHandlerFunc(handler.Serve))
+}
+
+//ServeServer serves the request with the middlewares and routes of s
+func (r *Request) ServeServer(t testing.TB, s *orbhttp.HTTPServer) *Response {
+	t.Helper()
+	return r.Do(t, s.Handler())
+}
+
+//Replay serves the requests recorded by the Capture middleware in path with handler, in
+//order. prepare is called on every request before it is served, e.g. to set the
+//credentials redacted by Capture. The test fails on requests whose body was truncated
+//or could not be redacted, as they cannot be sent again as they were.
+func Replay(t testing.TB, handler http.Handler, path string, prepare ...func(*http.Request)) []*Response {
+	t.Helper()
+	entries, err := orbhttp.ReadCapture(path)
+	if err != nil {
+		t.Fatalf("read capture error = %v", err)
+		return nil
+	}
+
+	responses := make([]*Response, 0, len(entries))
+	for i := range entries {
+		req, err := entries[i].NewRequest()
+		if err != nil {
+			t.Fatalf("replay entry %d error = %v", i, err)
+			return nil
+		}
+		for _, p := range prepare {
+			p(req)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		responses = append(responses, &Response{
+			t:      t,
+			Code:   rec.Code,
+			Header: rec.Header(),
+			Body:   rec.Body.Bytes(),
+		})
+	}
+	return responses
+}
+
+// ----------------------------------------------------------------------------
+// Response
+// ---------------------------------------------------------------------------
+
+//Response is a recorded response, the assertions fail the test and return the
+//response so that they can be chained
+type Response struct {
+	t      testing.TB
+	Code   int
+	Header http.Header
+	Body   []byte
+}
+
+//AssertStatus checks the status code
+func (r *Response) AssertStatus(code int) *Response {
+	r.t.Helper()
+	if r.Code != code {
+		r.t.Errorf("status = %d, want %d, body %s", r.Code, code, r.Body)
+	}
+	return r
+}
+
+//AssertHeader checks the value of a response header
+func (r *Response) AssertHeader(key string, value string) *Response {
+	r.t.Helper()
+	if got := r.Header.Get(key); got != value {
+		r.t.Errorf("header %s = %q, want %q", key, got, value)
+	}
+	return r
+}
+
+//Decode decodes the JSON body into v
+func (r *Response) Decode(v interface{}) *Response {
+	r.t.Helper()
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		r.t.Fatalf("decode body %s error = %v", r.Body, err)
+	}
+	return r
+}
+
+//JSON returns the value at path of the JSON body, the path is made of object keys
+//and array indexes separated by dots, e.g. "hits.0.name", an empty path is the whole body
+func (r *Response) JSON(path string) (interface{}, bool) {
+	var v interface{}
+	if err := json.Unmarshal(r.Body, &v); err != nil {
+		return nil, false
+	}
+	if len(path) == 0 {
+		return v, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			value, ok := node[key]
+			if !ok {
+				return nil, false
+			}
+			v = value
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+//AssertJSON checks that the value at path of the JSON body equals want,
+//want is compared after a JSON round trip so that e.g. ints match JSON numbers
+func (r *Response) AssertJSON(path string, want interface{}) *Response {
+	r.t.Helper()
+	got, ok := r.JSON(path)
+	if !ok {
+		r.t.Errorf("JSON path %q not found in %s", path, r.Body)
+		return r
+	}
+	data, err := json.Marshal(want)
+	if err != nil {
+		r.t.Fatalf("encode %v error = %v", want, err)
+	}
+	var normalized interface{}
+	json.Unmarshal(data, &normalized)
+	if !reflect.DeepEqual(got, normalized) {
+		r.t.Errorf("JSON path %q = %v, want %v", path, got, normalized)
+	}
+	return r
+}
+
+//AssertGolden compares the body with testdata/<name>.golden, JSON bodies are indented
+//first so that the files are readable. Run the tests with -update-golden to rewrite the files.
+func (r *Response) AssertGolden(name string) *Response {
+	r.t.Helper()
+	body := r.Body
+	var indented bytes.Buffer
+	if json.Indent(&indented, bytes.TrimSpace(r.Body), "", "  ") == nil {
+		body = append(indented.Bytes(), '\n')
+	}
+
+	path := filepath.Join("testdata", name+".golden")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			r.t.Fatalf("create testdata error = %v", err)
+		}
+		if err := ioutil.WriteFile(path, body, 0644); err != nil {
+			r.t.Fatalf("write golden file error = %v", err)
+		}
+		return r
+	}
+
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		r.t.Fatalf("read golden file error = %v, run the tests with -update-golden to create it", err)
+		return r
+	}
+	if !bytes.Equal(body, want) {
+		r.t.Errorf("body does not match %s\ngot:\n%s\nwant:\n%s", path, body, want)
+	}
+	return r
+}
@@ -0,0 +1,121 @@
+package httptest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	orbhttp "github.com/v-zhidu/orb/http"
+)
+
+type alert struct {
+	ID     int      `json:"id"`
+	Labels []string `json:"labels"`
+}
+
+var alertHandler = orbhttp.ApiHandlerFunc(func(ctx context.Context, req *http.Request) (interface{}, int) {
+	orbhttp.SetHeader(ctx, "X-Total", "2")
+	caller := "anonymous"
+	if principal := orbhttp.GetPrincipal(ctx); principal != nil {
+		caller = principal.ID
+	}
+	return map[string]interface{}{
+		"caller": caller,
+		"query":  req.URL.Query().Get("q"),
+		"alerts": []alert{{ID: 1, Labels: []string{"cpu"}}, {ID: 2}},
+	}, 0
+})
+
+//recordingT records failures instead of failing the test
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func (t *recordingT) Fatalf(format string, args ...interface{}) {
+	t.Errorf(format, args...)
+}
+
+func TestRequest_Serve(t *testing.T) {
+	Get("/alerts").
+		WithQuery("q", "cpu").
+		WithPrincipal(&orbhttp.Principal{ID: "ops"}).
+		Serve(t, alertHandler).
+		AssertStatus(http.StatusOK).
+		AssertHeader("X-Total", "2").
+		AssertHeader("Content-Type", "application/json").
+		AssertJSON("caller", "ops").
+		AssertJSON("query", "cpu").
+		AssertJSON("alerts.0.labels", []string{"cpu"}).
+		AssertJSON("alerts.1.id", 2).
+		AssertGolden("alerts")
+}
+
+func TestRequest_ServeServer(t *testing.T) {
+	s := orbhttp.NewHTTPServer("localhost", 0, "/api", orbhttp.WithoutAccessLog())
+	s.RegisterApiHandler("/alerts", alertHandler)
+
+	var body struct {
+		Alerts []alert `json:"alerts"`
+	}
+	Post("/api/alerts").
+		WithJSON(alert{ID: 3}).
+		ServeServer(t, s).
+		AssertStatus(http.StatusOK).
+		AssertJSON("caller", "anonymous").
+		Decode(&body)
+	if len(body.Alerts) != 2 {
+		t.Errorf("decoded alerts = %v, want 2", body.Alerts)
+	}
+}
+
+func TestResponse_AssertionsFail(t *testing.T) {
+	rt := &recordingT{}
+	Get("/alerts").Serve(rt, alertHandler).
+		AssertStatus(http.StatusCreated).
+		AssertHeader("X-Total", "3").
+		AssertJSON("alerts.5.id", 1).
+		AssertJSON("caller", "ops")
+	if len(rt.errors) != 4 {
+		t.Errorf("failures = %q, want 4", rt.errors)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "capture.har")
+
+	s := orbhttp.NewHTTPServer("localhost", 0, "", orbhttp.WithoutAccessLog())
+	s.Use(orbhttp.Capture(orbhttp.CaptureConfig{Path: path}))
+	s.RegisterApiHandler("/alerts", alertHandler)
+	Get("/alerts").WithQuery("q", "cpu").WithHeader("Authorization", "Bearer abc").ServeServer(t, s)
+	Get("/alerts").WithQuery("q", "disk").ServeServer(t, s)
+
+	var authorization []string
+	responses := Replay(t, s.Handler(), path, func(req *http.Request) {
+		authorization = append(authorization, req.Header.Get("Authorization"))
+		req.Header.Set("Authorization", "Bearer replayed")
+	})
+	if len(responses) != 2 {
+		t.Fatalf("Replay() = %d responses, want 2", len(responses))
+	}
+	responses[0].AssertStatus(http.StatusOK).AssertJSON("query", "cpu")
+	responses[1].AssertStatus(http.StatusOK).AssertJSON("query", "disk")
+	if authorization[0] != "[REDACTED]" || authorization[1] != "" {
+		t.Errorf("recorded Authorization = %q, want it redacted", authorization)
+	}
+}
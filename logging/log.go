@@ -8,6 +8,7 @@ import (
 	"context"
 
 	"github.com/sirupsen/logrus"
+	"github.com/v-zhidu/orb/trace"
 )
 
 type loggerKeyType int
@@ -36,6 +37,11 @@ func SetLevel(strlevel string) {
 	logger.SetLevel(level)
 }
 
+//GetLevel returns logger output level
+func GetLevel() string {
+	return logger.GetLevel().String()
+}
+
 //Debugln returns message logging by debug level
 func Debugln(message string) {
 	logEntry.Debug(message)
@@ -96,15 +102,24 @@ func NewContext(ctx context.Context, fields Fields) context.Context {
 	return context.WithValue(ctx, loggerKey, WithContext(ctx).WithFields(logrus.Fields(fields)))
 }
 
-//WithContext returns a logrus entry with fields in a same context
+//WithContext returns a logrus entry with fields in a same context,
+//trace_id and span_id are added if the context carries a span
 func WithContext(ctx context.Context) *logrus.Entry {
 	if ctx == nil {
 		return logEntry
 	}
 
+	entry := logEntry
 	if ctxLogEntry, ok := ctx.Value(loggerKey).(*logrus.Entry); ok {
-		return ctxLogEntry
+		entry = ctxLogEntry
+	}
+
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		entry = entry.WithFields(logrus.Fields{
+			"trace_id": sc.TraceID.String(),
+			"span_id":  sc.SpanID.String(),
+		})
 	}
 
-	return logEntry
+	return entry
 }
@@ -0,0 +1,37 @@
+package trace
+
+import "sync"
+
+//InMemoryExporter keeps finished spans in memory, mostly used in tests
+type InMemoryExporter struct {
+	sync.RWMutex
+	spans []SpanData
+}
+
+//NewInMemoryExporter returns an empty InMemoryExporter
+func NewInMemoryExporter() *InMemoryExporter {
+	return &InMemoryExporter{}
+}
+
+//ExportSpan implement Exporter interface
+func (e *InMemoryExporter) ExportSpan(data SpanData) {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = append(e.spans, data)
+}
+
+//Spans returns a copy of the exported spans in the order they ended
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.RLock()
+	defer e.RUnlock()
+	spans := make([]SpanData, len(e.spans))
+	copy(spans, e.spans)
+	return spans
+}
+
+//Reset drops all exported spans
+func (e *InMemoryExporter) Reset() {
+	e.Lock()
+	defer e.Unlock()
+	e.spans = nil
+}
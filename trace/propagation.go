@@ -0,0 +1,88 @@
+package trace
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//TraceparentHeader is the W3C trace context header name
+const TraceparentHeader = "traceparent"
+
+const traceparentVersion = "00"
+
+//ErrInvalidTraceparent is returned when the traceparent header can not be parsed
+var ErrInvalidTraceparent = errors.New("invalid traceparent")
+
+//ParseTraceparent parses a W3C traceparent header value,
+//e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	version := parts[0]
+	if len(version) != 2 || version == "ff" {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	//version 00 defines exactly four fields, future versions may append more
+	if version == traceparentVersion && len(parts) != 4 {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+
+	var sc SpanContext
+	if err := decodeHex(parts[1], sc.TraceID[:]); err != nil || !sc.TraceID.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	if err := decodeHex(parts[2], sc.SpanID[:]); err != nil || !sc.SpanID.IsValid() {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	var flags [1]byte
+	if err := decodeHex(parts[3], flags[:]); err != nil {
+		return SpanContext{}, ErrInvalidTraceparent
+	}
+	sc.Flags = flags[0]
+
+	return sc, nil
+}
+
+//FormatTraceparent returns the W3C traceparent header value of the span context
+func FormatTraceparent(sc SpanContext) string {
+	return fmt.Sprintf("%s-%s-%s-%02x", traceparentVersion, sc.TraceID, sc.SpanID, sc.Flags)
+}
+
+//Inject writes the span context in ctx into the request headers
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(TraceparentHeader, FormatTraceparent(sc))
+}
+
+//Extract returns a context carries the span context found in the request headers,
+//ctx is returned unchanged when the header is absent or malformed
+func Extract(ctx context.Context, header http.Header) context.Context {
+	value := header.Get(TraceparentHeader)
+	if value == "" {
+		return ctx
+	}
+	sc, err := ParseTraceparent(value)
+	if err != nil {
+		return ctx
+	}
+
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+func decodeHex(s string, dst []byte) error {
+	if len(s) != hex.EncodedLen(len(dst)) || strings.ToLower(s) != s {
+		return ErrInvalidTraceparent
+	}
+	_, err := hex.Decode(dst, []byte(s))
+	return err
+}
@@ -0,0 +1,298 @@
+package trace
+
+//This is a package that provides a lightweight, OpenTelemetry compatible
+//tracer. Span contexts are propagated with the W3C traceparent header.
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+type spanKeyType int
+
+const spanKey spanKeyType = iota
+
+//SpanKind describes the relationship between a span and its parent
+type SpanKind int
+
+const (
+	//SpanKindInternal is an internal operation within an application
+	SpanKindInternal SpanKind = iota
+	//SpanKindServer handles an incoming remote request
+	SpanKindServer
+	//SpanKindClient describes an outgoing remote request
+	SpanKindClient
+)
+
+//String returns the name of the span kind
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "internal"
+	}
+}
+
+//TraceID is a 16 bytes identifier shared by all spans of a trace
+type TraceID [16]byte
+
+//IsValid returns false if all bytes of the trace id are zero
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+//String returns the lower hex encoding of the trace id
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+//SpanID is a 8 bytes identifier of a span
+type SpanID [8]byte
+
+//IsValid returns false if all bytes of the span id are zero
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+//String returns the lower hex encoding of the span id
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+//FlagsSampled is the trace flag set when the trace is sampled
+const FlagsSampled = byte(0x01)
+
+//SpanContext is the part of a span propagated to other processes
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Flags   byte
+	Remote  bool
+}
+
+//IsValid returns true if both trace id and span id are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+//IsSampled returns true if the sampled flag is set
+func (sc SpanContext) IsSampled() bool {
+	return sc.Flags&FlagsSampled == FlagsSampled
+}
+
+//SpanData is a read only snapshot of a finished span handed to exporters
+type SpanData struct {
+	Name         string
+	Kind         SpanKind
+	SpanContext  SpanContext
+	ParentSpanID SpanID
+	StartTime    time.Time
+	EndTime      time.Time
+	Attributes   map[string]interface{}
+	Error        error
+}
+
+//Duration returns the elapsed time of the span
+func (d SpanData) Duration() time.Duration {
+	return d.EndTime.Sub(d.StartTime)
+}
+
+//Span records a single operation within a trace
+type Span struct {
+	sync.Mutex
+	data   SpanData
+	ended  bool
+	tracer *Tracer
+}
+
+//SpanContext returns the span context of the span
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.data.SpanContext
+}
+
+//SetAttribute set a key value attribute on the span
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.data.Attributes[key] = value
+}
+
+//RecordError marks the span as failed
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.Lock()
+	defer s.Unlock()
+	s.data.Error = err
+}
+
+//End finishes the span and hands it to the exporter, only the first call takes effect
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.Lock()
+	if s.ended {
+		s.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.EndTime = time.Now()
+	data := s.data
+	data.Attributes = make(map[string]interface{}, len(s.data.Attributes))
+	for k, v := range s.data.Attributes {
+		data.Attributes[k] = v
+	}
+	s.Unlock()
+
+	if data.SpanContext.IsSampled() {
+		s.tracer.export(data)
+	}
+}
+
+//Exporter receives finished spans
+type Exporter interface {
+	ExportSpan(SpanData)
+}
+
+//Tracer creates spans and sends them to an exporter
+type Tracer struct {
+	sync.RWMutex
+	exporter Exporter
+}
+
+//NewTracer returns a tracer that exports spans to exporter, nil exporter drops spans
+func NewTracer(exporter Exporter) *Tracer {
+	return &Tracer{
+		exporter: exporter,
+	}
+}
+
+//SetExporter replace the exporter of the tracer
+func (t *Tracer) SetExporter(exporter Exporter) {
+	t.Lock()
+	defer t.Unlock()
+	t.exporter = exporter
+}
+
+func (t *Tracer) export(data SpanData) {
+	t.RLock()
+	exporter := t.exporter
+	t.RUnlock()
+	if exporter != nil {
+		exporter.ExportSpan(data)
+	}
+}
+
+//Start creates a span as a child of the span in ctx, or a new trace if there is no parent
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	parent := SpanContextFromContext(ctx)
+	sc := SpanContext{
+		TraceID: parent.TraceID,
+		SpanID:  newSpanID(),
+		Flags:   parent.Flags,
+	}
+	if !parent.IsValid() {
+		sc.TraceID = newTraceID()
+		sc.Flags = FlagsSampled
+	}
+
+	span := &Span{
+		data: SpanData{
+			Name:         name,
+			Kind:         kind,
+			SpanContext:  sc,
+			ParentSpanID: parent.SpanID,
+			StartTime:    time.Now(),
+			Attributes:   make(map[string]interface{}),
+		},
+		tracer: t,
+	}
+
+	return ContextWithSpan(ctx, span), span
+}
+
+var defaultTracer = NewTracer(nil)
+
+//SetExporter replace the exporter of the default tracer
+func SetExporter(exporter Exporter) {
+	defaultTracer.SetExporter(exporter)
+}
+
+//DefaultTracer returns the tracer used by package level functions
+func DefaultTracer() *Tracer {
+	return defaultTracer
+}
+
+//Start creates a span with the default tracer
+func Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return defaultTracer.Start(ctx, name, kind)
+}
+
+//ContextWithSpan returns a context carries the span
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey, span)
+}
+
+//SpanFromContext returns the current span in ctx, nil if there is no local span
+func SpanFromContext(ctx context.Context) *Span {
+	if ctx == nil {
+		return nil
+	}
+	span, _ := ctx.Value(spanKey).(*Span)
+	return span
+}
+
+//ContextWithRemoteSpanContext returns a context carries a span context extracted from a remote process
+func ContextWithRemoteSpanContext(ctx context.Context, sc SpanContext) context.Context {
+	sc.Remote = true
+	return context.WithValue(ctx, spanKey, sc)
+}
+
+//SpanContextFromContext returns the current span context in ctx
+func SpanContextFromContext(ctx context.Context) SpanContext {
+	if ctx == nil {
+		return SpanContext{}
+	}
+	switch v := ctx.Value(spanKey).(type) {
+	case *Span:
+		return v.SpanContext()
+	case SpanContext:
+		return v
+	}
+
+	return SpanContext{}
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+	return id
+}
@@ -0,0 +1,135 @@
+package trace
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		sampled bool
+		wantErr bool
+	}{
+		{
+			name:    "sampled traceparent",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			want:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			sampled: true,
+		},
+		{
+			name:  "not sampled traceparent",
+			value: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+			want:  "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00",
+		},
+		{
+			name:    "future version with extra fields",
+			value:   "01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+			want:    "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			sampled: true,
+		},
+		{
+			name:    "invalid version",
+			value:   "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "zero trace id",
+			value:   "00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "upper case hex",
+			value:   "00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+			wantErr: true,
+		},
+		{
+			name:    "short span id",
+			value:   "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa-01",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseTraceparent(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseTraceparent() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if FormatTraceparent(got) != tt.want {
+				t.Errorf("FormatTraceparent() = %v, want %v", FormatTraceparent(got), tt.want)
+			}
+			if got.IsSampled() != tt.sampled {
+				t.Errorf("IsSampled() = %v, want %v", got.IsSampled(), tt.sampled)
+			}
+		})
+	}
+}
+
+func TestTracer_Start(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	ctx, root := tracer.Start(context.Background(), "root", SpanKindServer)
+	_, child := tracer.Start(ctx, "child", SpanKindClient)
+	child.SetAttribute("key", "value")
+	child.End()
+	child.End()
+	root.End()
+
+	spans := exporter.Spans()
+	if len(spans) != 2 {
+		t.Fatalf("Spans() length = %v, want 2", len(spans))
+	}
+	if spans[0].Name != "child" || spans[1].Name != "root" {
+		t.Errorf("Spans() = %v, %v, want child, root", spans[0].Name, spans[1].Name)
+	}
+	if spans[0].SpanContext.TraceID != spans[1].SpanContext.TraceID {
+		t.Errorf("child trace id = %v, want %v", spans[0].SpanContext.TraceID, spans[1].SpanContext.TraceID)
+	}
+	if spans[0].ParentSpanID != spans[1].SpanContext.SpanID {
+		t.Errorf("child parent = %v, want %v", spans[0].ParentSpanID, spans[1].SpanContext.SpanID)
+	}
+	if spans[0].Attributes["key"] != "value" {
+		t.Errorf("child attributes = %v", spans[0].Attributes)
+	}
+}
+
+func TestInjectExtract(t *testing.T) {
+	exporter := NewInMemoryExporter()
+	tracer := NewTracer(exporter)
+
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00"
+	header := http.Header{}
+	header.Set(TraceparentHeader, parent)
+
+	ctx := Extract(context.Background(), header)
+	if !SpanContextFromContext(ctx).Remote {
+		t.Errorf("Extract() span context is not remote")
+	}
+
+	ctx, span := tracer.Start(ctx, "server", SpanKindServer)
+	out := http.Header{}
+	Inject(ctx, out)
+	span.End()
+
+	got, err := ParseTraceparent(out.Get(TraceparentHeader))
+	if err != nil {
+		t.Fatalf("Inject() traceparent error = %v", err)
+	}
+	if got.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Inject() trace id = %v", got.TraceID)
+	}
+	if got.SpanID != span.SpanContext().SpanID {
+		t.Errorf("Inject() span id = %v, want %v", got.SpanID, span.SpanContext().SpanID)
+	}
+	if len(exporter.Spans()) != 0 {
+		t.Errorf("not sampled span should not be exported")
+	}
+}
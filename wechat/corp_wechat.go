@@ -1,6 +1,7 @@
 package wechat
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 
@@ -40,7 +41,12 @@ func NewCorpWechat(corpID string, corpSecret string) *CorpWechat {
 
 //GetAccessToken 获取企业微信Access Token
 func (w *CorpWechat) GetAccessToken() (*CorpWechatAccessTokenResponse, error) {
-	data, err := http.Get(CorpWechatAccessTokenURL, map[string]string{
+	return w.GetAccessTokenWithContext(context.Background())
+}
+
+//GetAccessTokenWithContext 获取企业微信Access Token, the request is cancelled with ctx
+func (w *CorpWechat) GetAccessTokenWithContext(ctx context.Context) (*CorpWechatAccessTokenResponse, error) {
+	data, err := http.GetWithContext(ctx, CorpWechatAccessTokenURL, map[string]string{
 		"corpid":     w.CorpID,
 		"corpsecret": w.CorpSecret,
 	})
@@ -64,6 +70,12 @@ func (w *CorpWechat) GetAccessToken() (*CorpWechatAccessTokenResponse, error) {
 //errcode=0 创建成功
 //errcode=86215 chatid或chatname已经存在
 func (w *CorpWechat) CreateChat(accessToken string,
+	chat *CorpWechatChatInfo) (*CorpWechatCreateChatResponse, error) {
+	return w.CreateChatWithContext(context.Background(), accessToken, chat)
+}
+
+//CreateChatWithContext creates a wechat group, the request is cancelled with ctx
+func (w *CorpWechat) CreateChatWithContext(ctx context.Context, accessToken string,
 	chat *CorpWechatChatInfo) (*CorpWechatCreateChatResponse, error) {
 	logging.Info("create chat request", logging.Fields{
 		"chatName": chat.Name,
@@ -77,7 +89,7 @@ func (w *CorpWechat) CreateChat(accessToken string,
 		logging.WithError("json marshal error", err)
 		return nil, err
 	}
-	data, err := http.PostJSON(fmt.Sprintf(CorpWechatCreateChatURL, accessToken), body, nil)
+	data, err := http.PostJSONWithContext(ctx, fmt.Sprintf(CorpWechatCreateChatURL, accessToken), body, nil)
 	if err != nil {
 		logging.WithError("create chat failed", err)
 		return nil, err
@@ -99,6 +111,12 @@ func (w *CorpWechat) CreateChat(accessToken string,
 
 //EditChat 修改群聊会话信息
 func (w *CorpWechat) EditChat(accessToken string, chat *CorpWechatChatInfo) (*CorpWechatResponse, error) {
+	return w.EditChatWithContext(context.Background(), accessToken, chat)
+}
+
+//EditChatWithContext 修改群聊会话信息, the requests are cancelled with ctx
+func (w *CorpWechat) EditChatWithContext(ctx context.Context, accessToken string,
+	chat *CorpWechatChatInfo) (*CorpWechatResponse, error) {
 	logging.Info("edit wechat request", logging.Fields{
 		"chatName": chat.Name,
 		"chatId":   chat.ChatID,
@@ -106,7 +124,7 @@ func (w *CorpWechat) EditChat(accessToken string, chat *CorpWechatChatInfo) (*Co
 		"userList": chat.UserList,
 	})
 	//获取会话消息
-	chatInfo, err := w.GetChatInfo(accessToken, chat.ChatID)
+	chatInfo, err := w.GetChatInfoWithContext(ctx, accessToken, chat.ChatID)
 	if err != nil {
 		return nil, err
 	}
@@ -130,7 +148,7 @@ func (w *CorpWechat) EditChat(accessToken string, chat *CorpWechatChatInfo) (*Co
 		"add_user_list": addUserList,
 	}
 
-	data, err := http.PostMap(fmt.Sprintf(CorpWechatEditChatURL, accessToken), body, nil)
+	data, err := http.PostMapWithContext(ctx, fmt.Sprintf(CorpWechatEditChatURL, accessToken), body, nil)
 	if err != nil {
 		logging.WithError("edit chat information failed", err)
 		return nil, err
@@ -152,7 +170,13 @@ func (w *CorpWechat) EditChat(accessToken string, chat *CorpWechatChatInfo) (*Co
 
 //GetChatInfo 获取群聊会话信息
 func (w *CorpWechat) GetChatInfo(accessToken string, chatid string) (*CorpWechatChatInfo, error) {
-	data, err := http.Get(CorpWehcatChatInfoURL, map[string]string{
+	return w.GetChatInfoWithContext(context.Background(), accessToken, chatid)
+}
+
+//GetChatInfoWithContext 获取群聊会话信息, the request is cancelled with ctx
+func (w *CorpWechat) GetChatInfoWithContext(ctx context.Context, accessToken string,
+	chatid string) (*CorpWechatChatInfo, error) {
+	data, err := http.GetWithContext(ctx, CorpWehcatChatInfoURL, map[string]string{
 		"access_token": accessToken,
 		"chatid":       chatid,
 	})
@@ -176,6 +200,12 @@ func (w *CorpWechat) GetChatInfo(accessToken string, chatid string) (*CorpWechat
 
 //SendChatMessage 发送群聊消息
 func (w *CorpWechat) SendChatMessage(accessToken string,
+	message *CorpWechatChatMessageRequest) (*CorpWechatResponse, error) {
+	return w.SendChatMessageWithContext(context.Background(), accessToken, message)
+}
+
+//SendChatMessageWithContext 发送群聊消息, the request is cancelled with ctx
+func (w *CorpWechat) SendChatMessageWithContext(ctx context.Context, accessToken string,
 	message *CorpWechatChatMessageRequest) (*CorpWechatResponse, error) {
 	logging.Info("send chat message", logging.Fields{
 		"chatid":      message.ChatID,
@@ -187,7 +217,7 @@ func (w *CorpWechat) SendChatMessage(accessToken string,
 		logging.WithError("json marshal error", err)
 		return nil, err
 	}
-	data, err := http.PostJSON(fmt.Sprintf(CorpWechatSendChatMessageURL, accessToken), body, nil)
+	data, err := http.PostJSONWithContext(ctx, fmt.Sprintf(CorpWechatSendChatMessageURL, accessToken), body, nil)
 	if err != nil {
 		logging.WithError("send chat message failed", err)
 		return nil, err
@@ -0,0 +1,22 @@
+package wechat
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/v-zhidu/orb/health"
+)
+
+//AccessTokenChecker returns a health.Checker that fails when the access token can not be retrieved
+func AccessTokenChecker(w *CorpWechat) health.Checker {
+	return health.CheckerFunc(func(ctx context.Context) error {
+		response, err := w.GetAccessTokenWithContext(ctx)
+		if err != nil {
+			return err
+		}
+		if response.ErrorCode != 0 {
+			return fmt.Errorf("get wechat access token errcode %d: %s", response.ErrorCode, response.ErrorMsg)
+		}
+		return nil
+	})
+}